@@ -0,0 +1,135 @@
+package retryableredis
+
+import (
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// RetryOption overrides how an action wrapped with WrapAction is retried
+type RetryOption func(*retryOpts)
+
+type retryOpts struct {
+	policy         RetryPolicy
+	backoff        *Backoff
+	maxAttempts    int
+	maxAttemptsSet bool
+	noReconnect    bool
+	wait           *waitOpts
+}
+
+// WithActionMaxAttempts sets the max number of attempts including the first
+// one, 0 means no limit
+func WithActionMaxAttempts(n int) RetryOption {
+	return func(o *retryOpts) {
+		o.maxAttempts = n
+		o.maxAttemptsSet = true
+	}
+}
+
+// WithActionBackoff sets the backoff between retries, which is also used for
+// error replies that have their own in ErrorBackoffs
+func WithActionBackoff(b Backoff) RetryOption {
+	return func(o *retryOpts) {
+		o.backoff = &b
+	}
+}
+
+// WithActionRetryPolicy sets the RetryPolicy, which takes precedence over
+// WithActionMaxAttempts and WithActionBackoff
+func WithActionRetryPolicy(p RetryPolicy) RetryOption {
+	return func(o *retryOpts) {
+		o.policy = p
+	}
+}
+
+// WithoutReconnect makes errors that need a reconnect final, the conn then
+// reconnects in the background. Do returns ErrNotConnected if the conn isn't
+// connected to begin with.
+func WithoutReconnect() RetryOption {
+	return func(o *retryOpts) {
+		o.noReconnect = true
+	}
+}
+
+// isZero reports whether no options are set
+func (o retryOpts) isZero() bool {
+	return o.policy == nil && o.backoff == nil && !o.maxAttemptsSet && !o.noReconnect &&
+		o.wait == nil
+}
+
+// retryAction is an action wrapped with WrapAction
+type retryAction struct {
+	radix.Action
+	opts retryOpts
+}
+
+// WrapAction returns a, with the retry behaviour of the conn performing it
+// overridden by opts, e.g to retry a latency sensitive command only once:
+//
+//	conn.Do(WrapAction(Cmd(&val, "GET", "key"), WithActionMaxAttempts(2)))
+//
+// The options have no effect when it's performed by anything else.
+func WrapAction(a radix.Action, opts ...RetryOption) radix.Action {
+	ra := &retryAction{Action: a}
+	if inner, ok := a.(*retryAction); ok {
+		ra = &retryAction{Action: inner.Action, opts: inner.opts}
+	}
+
+	for _, opt := range opts {
+		opt(&ra.opts)
+	}
+	return ra
+}
+
+// unwrapAction returns the action wrapped with WrapAction, if it is one, and
+// its options. TypedCmds and MaybeNilCmds are unwrapped to their cmd.
+func unwrapAction(a radix.Action) (radix.Action, retryOpts) {
+	var opts retryOpts
+	if ra, ok := a.(*retryAction); ok {
+		a, opts = ra.Action, ra.opts
+	}
+	if wc, ok := a.(wrappedCmd); ok {
+		a = wc.unwrapCmd()
+	}
+	return a, opts
+}
+
+// actionRetryPolicy returns the retry policy for an action with the options o,
+// and whether it overrides the backoffs for error replies
+func (conf *DialConfig) actionRetryPolicy(o retryOpts) (RetryPolicy, bool) {
+	if o.policy != nil {
+		return o.policy, true
+	}
+
+	p := conf.retryPolicy()
+	if o.backoff != nil {
+		bp := BackoffPolicy{Backoff: *o.backoff}
+		if confP, ok := p.(BackoffPolicy); ok {
+			bp.MaxAttempts = confP.MaxAttempts
+		}
+		p = bp
+	}
+	if o.maxAttemptsSet {
+		if bp, ok := p.(BackoffPolicy); ok {
+			bp.MaxAttempts = o.maxAttempts
+			p = bp
+		} else {
+			p = maxAttemptsPolicy{RetryPolicy: p, maxAttempts: o.maxAttempts}
+		}
+	}
+	return p, o.backoff != nil
+}
+
+// maxAttemptsPolicy limits the attempts of a RetryPolicy
+type maxAttemptsPolicy struct {
+	RetryPolicy
+	maxAttempts int
+}
+
+func (p maxAttemptsPolicy) NextDelay(attempt int, err error) (time.Duration, bool) {
+	if p.maxAttempts > 0 && attempt+1 >= p.maxAttempts {
+		return 0, false
+	}
+	return p.RetryPolicy.NextDelay(attempt, err)
+}
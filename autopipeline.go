@@ -0,0 +1,157 @@
+package retryableredis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// DefaultAutoPipelineLimit is the default DialConfig.AutoPipelineLimit
+const DefaultAutoPipelineLimit = 128
+
+// autoPipeline batches the cmds of concurrent Do calls into pipelines, see
+// DialConfig.AutoPipelineWindow
+type autoPipeline struct {
+	rc *retryableRedisConn
+
+	mu    sync.Mutex
+	calls []*pipelinedCall
+	timer *time.Timer
+}
+
+type pipelinedCall struct {
+	cmd  radix.CmdAction
+	done chan struct{}
+
+	// deadline is the deadline of the call's ctx, zero if it has none
+	deadline time.Time
+
+	attempts int
+	err      error
+}
+
+// batchable reports whether a can be performed as part of a pipeline, which
+// excludes cmds that change the state of the conn or block it
+func batchable(a radix.Action) (radix.CmdAction, bool) {
+	var cmd radix.CmdAction
+	switch v := a.(type) {
+	case *RetryableCmd:
+		cmd = v
+	case *RetryableFlatCmd:
+		cmd = v
+	default:
+		return nil, false
+	}
+
+	name := strings.ToUpper(actionCmdNames(a)[0])
+	if statefulCommands[name] {
+		return nil, false
+	}
+	if _, ok := blockingCommands[name]; ok {
+		return nil, false
+	}
+	return cmd, true
+}
+
+// do performs cmd in the next pipeline, waiting up to the window for more
+// calls to join it
+func (ap *autoPipeline) do(ctx context.Context, cmd radix.CmdAction) (int, error) {
+	call := &pipelinedCall{cmd: cmd, done: make(chan struct{})}
+	call.deadline, _ = ctx.Deadline()
+
+	ap.mu.Lock()
+	ap.calls = append(ap.calls, call)
+	limit := ap.rc.config().AutoPipelineLimit
+	if limit < 1 {
+		limit = DefaultAutoPipelineLimit
+	}
+	switch {
+	case len(ap.calls) >= limit:
+		if ap.timer != nil {
+			ap.timer.Stop()
+		}
+		go ap.flush()
+	case len(ap.calls) == 1:
+		ap.timer = time.AfterFunc(ap.rc.config().AutoPipelineWindow, ap.flush)
+	}
+	ap.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.attempts, call.err
+	case <-ctx.Done():
+	}
+
+	// it can only be given up on if it wasn't sent yet
+	ap.mu.Lock()
+	for i, c := range ap.calls {
+		if c == call {
+			ap.calls = append(ap.calls[:i], ap.calls[i+1:]...)
+			ap.mu.Unlock()
+			return 0, ctx.Err()
+		}
+	}
+	ap.mu.Unlock()
+
+	<-call.done
+	return call.attempts, call.err
+}
+
+// flush performs the waiting calls in a single pipeline
+func (ap *autoPipeline) flush() {
+	ap.mu.Lock()
+	calls := ap.calls
+	ap.calls = nil
+	ap.timer = nil
+	ap.mu.Unlock()
+
+	if len(calls) < 1 {
+		return
+	}
+
+	// the calls can't be given up on anymore, so the pipeline is only given
+	// up on once all their deadlines passed, e.g while queued offline
+	ctx, cancel := pipelineContext(calls)
+	defer cancel()
+
+	if len(calls) == 1 {
+		calls[0].attempts, calls[0].err = ap.rc.doRetried(ctx, calls[0].cmd, retryOpts{})
+		close(calls[0].done)
+		return
+	}
+
+	cmds := make([]radix.CmdAction, len(calls))
+	for i, call := range calls {
+		cmds[i] = call.cmd
+	}
+	p := Pipeline(cmds...).(*pipeline)
+	attempts, err := ap.rc.doRetried(ctx, p, retryOpts{})
+
+	for i, call := range calls {
+		call.attempts = attempts
+		if p.received[i] {
+			call.err = p.errs[i]
+		} else {
+			call.err = err
+		}
+		close(call.done)
+	}
+}
+
+// pipelineContext returns a context with the latest deadline of the calls, or
+// without one if any of them has none
+func pipelineContext(calls []*pipelinedCall) (context.Context, context.CancelFunc) {
+	var latest time.Time
+	for _, call := range calls {
+		if call.deadline.IsZero() {
+			return context.Background(), func() {}
+		}
+		if call.deadline.After(latest) {
+			latest = call.deadline
+		}
+	}
+	return context.WithDeadline(context.Background(), latest)
+}
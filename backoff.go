@@ -0,0 +1,146 @@
+package retryableredis
+
+import (
+	"math"
+	"math/rand"
+	"time"
+)
+
+var (
+	// DefaultReconnectBackoff is used for the delays between reconnect
+	// attempts when DialConfig.ReconnectBackoff is not set
+	DefaultReconnectBackoff = Backoff{
+		Initial:    time.Millisecond * 500,
+		Multiplier: 2,
+		Max:        time.Second * 10,
+		Jitter:     0.2,
+	}
+
+	// DefaultRetryBackoff is used for the delays between retries (e.g on
+	// LOADING errors) when DialConfig.RetryBackoff is not set
+	DefaultRetryBackoff = Backoff{
+		Initial:    time.Millisecond * 250,
+		Multiplier: 1.5,
+		Max:        time.Second * 5,
+		Jitter:     0.2,
+	}
+)
+
+// DefaultErrorBackoffs are used for retrying error replies with the given
+// prefixes when DialConfig.ErrorBackoffs doesn't have an entry for them
+var DefaultErrorBackoffs = map[string]Backoff{
+	"CLUSTERDOWN": {
+		Initial:    time.Millisecond * 100,
+		Multiplier: 2,
+		Max:        time.Second * 2,
+		Jitter:     0.2,
+	},
+	"TRYAGAIN": {
+		Initial:    time.Millisecond * 50,
+		Multiplier: 2,
+		Max:        time.Second,
+		Jitter:     0.2,
+	},
+	"MASTERDOWN": {
+		Initial:    time.Millisecond * 250,
+		Multiplier: 2,
+		Max:        time.Second * 5,
+		Jitter:     0.2,
+	},
+	"BUSY": {
+		Initial:    time.Millisecond * 100,
+		Multiplier: 1.5,
+		Max:        time.Second,
+		Jitter:     0.2,
+	},
+}
+
+// Backoff is an exponential backoff policy with jitter
+type Backoff struct {
+	// Initial is the delay before the first attempt
+	Initial time.Duration
+
+	// Multiplier is applied to the delay after every attempt, values below 1
+	// are treated as 1 (a fixed delay)
+	Multiplier float64
+
+	// Max caps the delay, 0 means no cap
+	Max time.Duration
+
+	// Jitter randomizes every delay by up to this fraction of it in either
+	// direction (0-1), so that many conns don't all retry at the same instant
+	Jitter float64
+}
+
+// Delay returns the delay to wait before the given attempt, starting at 0
+func (b Backoff) Delay(attempt int) time.Duration {
+	mult := b.Multiplier
+	if mult < 1 {
+		mult = 1
+	}
+
+	d := float64(b.Initial) * math.Pow(mult, float64(attempt))
+	if b.Max > 0 && d > float64(b.Max) {
+		d = float64(b.Max)
+	}
+
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	if d >= math.MaxInt64 {
+		return math.MaxInt64
+	} else if d < 0 {
+		return 0
+	}
+
+	return time.Duration(d)
+}
+
+func (b Backoff) orDefault(def Backoff) Backoff {
+	if b == (Backoff{}) {
+		return def
+	}
+
+	return b
+}
+
+// RetryPolicy decides whether to make another attempt after a failed one, and
+// how long to wait before doing so
+type RetryPolicy interface {
+	// NextDelay is called after the given attempt (starting at 0) failed with
+	// err, returning the delay before the next attempt, or false to give up
+	NextDelay(attempt int, err error) (time.Duration, bool)
+}
+
+// BackoffPolicy is a RetryPolicy that waits according to Backoff and gives up
+// after MaxAttempts
+type BackoffPolicy struct {
+	Backoff
+
+	// MaxAttempts is the max number of attempts including the first one, 0
+	// means no limit
+	MaxAttempts int
+}
+
+var _ RetryPolicy = BackoffPolicy{}
+
+// NextDelay implements RetryPolicy
+func (p BackoffPolicy) NextDelay(attempt int, err error) (time.Duration, bool) {
+	if p.MaxAttempts > 0 && attempt+1 >= p.MaxAttempts {
+		return 0, false
+	}
+
+	return p.Delay(attempt), true
+}
+
+// errorBackoff returns the backoff for error replies with the given prefix, if
+// there is one specific to it
+func (conf *DialConfig) errorBackoff(prefix string) (Backoff, bool) {
+	if b, ok := conf.ErrorBackoffs[prefix]; ok {
+		return b, true
+	}
+
+	b, ok := DefaultErrorBackoffs[prefix]
+	return b, ok
+}
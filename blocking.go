@@ -0,0 +1,252 @@
+package retryableredis
+
+import (
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// blockedMin is how long an attempt of a blocking command has to have been
+// running before failing for it to be considered to have been blocking
+const blockedMin = time.Second
+
+// blockingTimeoutArg is where the timeout of a blocking command is
+type blockingTimeoutArg int
+
+const (
+	// timeoutLast is a timeout in seconds in the last arg (BLPOP)
+	timeoutLast blockingTimeoutArg = iota
+	// timeoutFirst is a timeout in seconds in the first arg (BLMPOP)
+	timeoutFirst
+	// timeoutBlockOpt is a timeout in milliseconds after BLOCK (XREAD)
+	timeoutBlockOpt
+	// timeoutLastMs is a timeout in milliseconds in the last arg (WAIT)
+	timeoutLastMs
+)
+
+// blockingCommands block until there's something to return or their timeout
+// passes
+var blockingCommands = map[string]blockingTimeoutArg{
+	"BLPOP": timeoutLast, "BRPOP": timeoutLast, "BRPOPLPUSH": timeoutLast,
+	"BLMOVE": timeoutLast, "BZPOPMIN": timeoutLast, "BZPOPMAX": timeoutLast,
+	"BLMPOP": timeoutFirst, "BZMPOP": timeoutFirst,
+	"XREAD": timeoutBlockOpt, "XREADGROUP": timeoutBlockOpt,
+	"WAIT": timeoutLastMs,
+}
+
+// blockTimeoutIdx returns the index of the timeout arg of a blocking command,
+// or -1 if it isn't blocking
+func blockTimeoutIdx(cmd string, args []string) int {
+	where, ok := blockingCommands[strings.ToUpper(cmd)]
+	if !ok || len(args) < 1 {
+		return -1
+	}
+
+	switch where {
+	case timeoutLast, timeoutLastMs:
+		return len(args) - 1
+	case timeoutFirst:
+		return 0
+	}
+
+	for i, arg := range args[:len(args)-1] {
+		switch strings.ToUpper(arg) {
+		case "STREAMS":
+			// without BLOCK it doesn't block
+			return -1
+		case "BLOCK":
+			return i + 1
+		}
+	}
+	return -1
+}
+
+// blockTimeout returns how long the action blocks for if it's a blocking
+// command made with Cmd, 0 meaning forever
+func blockTimeout(a radix.Action) (time.Duration, bool) {
+	cmd, ok := a.(*RetryableCmd)
+	if !ok {
+		return 0, false
+	}
+
+	i := blockTimeoutIdx(cmd.cmd, cmd.args)
+	if i < 0 {
+		return 0, false
+	}
+
+	arg := cmd.args[i]
+	if where := blockingCommands[strings.ToUpper(cmd.cmd)]; where == timeoutBlockOpt || where == timeoutLastMs {
+		ms, err := strconv.ParseInt(arg, 10, 64)
+		if err != nil {
+			return 0, false
+		}
+		return time.Duration(ms) * time.Millisecond, true
+	}
+
+	secs, err := strconv.ParseFloat(arg, 64)
+	if err != nil {
+		return 0, false
+	}
+	return time.Duration(secs * float64(time.Second)), true
+}
+
+// setBlockTimeout changes the timeout of a blocking command made with Cmd to
+// d, rounded up to what the command accepts
+func setBlockTimeout(a radix.Action, d time.Duration) {
+	cmd, ok := a.(*RetryableCmd)
+	if !ok {
+		return
+	}
+
+	i := blockTimeoutIdx(cmd.cmd, cmd.args)
+	if i < 0 {
+		return
+	}
+
+	// 0 would block forever
+	if d < time.Millisecond {
+		d = time.Millisecond
+	}
+
+	var arg string
+	switch where := blockingCommands[strings.ToUpper(cmd.cmd)]; {
+	case where == timeoutBlockOpt || where == timeoutLastMs:
+		arg = strconv.FormatInt(int64(math.Ceil(float64(d)/float64(time.Millisecond))), 10)
+	case strings.Contains(cmd.args[i], "."):
+		// fractions of a second are only supported since redis 6
+		arg = strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+	default:
+		arg = strconv.FormatInt(int64(math.Ceil(d.Seconds())), 10)
+	}
+
+	args := append([]string{}, cmd.args...)
+	args[i] = arg
+	cmd.args = args
+	cmd.inner = nil
+}
+
+// attemptTimeout returns the timeout for an attempt of a, which is extended by
+// how long a blocks for
+func (conf *DialConfig) attemptTimeout(a radix.Action) time.Duration {
+	timeout := conf.CommandTimeout
+	if timeout <= 0 {
+		return 0
+	}
+
+	if block, ok := blockTimeout(a); ok {
+		if block == 0 {
+			// there's no telling how long it blocks for
+			return 0
+		}
+		timeout += block
+	}
+	return timeout
+}
+
+// radixReadTimeout is the read timeout radix.Dial sets by default
+const radixReadTimeout = 10 * time.Second
+
+// dialReadTimeout returns the read timeout radix sets before every read on
+// the conns it dials, going by DialOpts. It's 0 for conns from Dialer and if
+// ReadTimeout is set, which replaces it.
+func (conf *DialConfig) dialReadTimeout() time.Duration {
+	if conf.ReadTimeout > 0 || conf.Dialer != nil {
+		return 0
+	}
+
+	// radix's dialOpts aren't exported, the opts are applied to one made
+	// with reflect instead
+	do := reflect.New(reflect.TypeOf(radix.DialOpt(nil)).In(0).Elem())
+	args := []reflect.Value{do}
+	reflect.ValueOf(radix.DialReadTimeout(radixReadTimeout)).Call(args)
+	for _, opt := range conf.DialOpts {
+		reflect.ValueOf(opt).Call(args)
+	}
+
+	f := do.Elem().FieldByName("readTimeout")
+	if f.Kind() != reflect.Int64 {
+		return radixReadTimeout
+	}
+	return time.Duration(f.Int())
+}
+
+// setDeadlines sets the ReadTimeout and WriteTimeout deadlines on netConn for
+// an attempt of a. Without ReadTimeout blocking commands get the time they
+// block for on top of the read timeout radix sets instead. It returns a func
+// resetting them, or nil if none were set.
+func (conf *DialConfig) setDeadlines(netConn net.Conn, a radix.Action) func() {
+	if netConn == nil {
+		return nil
+	}
+
+	block, blocking := blockTimeout(a)
+	now := time.Now()
+	set := false
+	if conf.WriteTimeout > 0 {
+		netConn.SetWriteDeadline(now.Add(conf.WriteTimeout))
+		set = true
+	}
+	if conf.ReadTimeout > 0 {
+		// there's no telling how long it blocks for
+		if !blocking || block > 0 {
+			netConn.SetReadDeadline(now.Add(conf.ReadTimeout + block))
+		}
+		set = true
+	}
+
+	var wg sync.WaitGroup
+	var stop chan struct{}
+	if perRead := conf.dialReadTimeout(); blocking && perRead > 0 {
+		stop = make(chan struct{})
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			extendReadDeadline(netConn, perRead, now, block, stop)
+		}()
+	}
+
+	if !set && stop == nil {
+		return nil
+	}
+	return func() {
+		if stop != nil {
+			close(stop)
+			wg.Wait()
+		}
+		netConn.SetDeadline(time.Time{})
+	}
+}
+
+// extendReadDeadline keeps moving the read deadline, which radix sets to
+// perRead from now before every read, to perRead after the block time passed
+// since start, or removes it if the command blocks forever. It returns once
+// the block time passed or stop is closed.
+func extendReadDeadline(netConn net.Conn, perRead time.Duration, start time.Time, block time.Duration, stop <-chan struct{}) {
+	var deadline time.Time
+	if block > 0 {
+		deadline = start.Add(block + perRead)
+	}
+
+	// radix sets its deadline when it starts reading, which could be after
+	// this sets it, so it's done again before radix's passes
+	ticker := time.NewTicker(perRead/2 + 1)
+	defer ticker.Stop()
+	for {
+		if block > 0 && time.Since(start) >= block {
+			return
+		}
+		netConn.SetReadDeadline(deadline)
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package retryableredis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+	"github.com/mediocregopher/radix/v3"
+)
+
+func TestBlockingExtendsDialReadTimeout(t *testing.T) {
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		if args[0] == "BLPOP" {
+			return retryableredistest.Value("v").After(300 * time.Millisecond)
+		}
+		return retryableredistest.OK()
+	})
+	defer srv.Close()
+
+	var cb callbacks
+	conn := dialProxy(t, srv.Addr(), &cb, retryableredis.DialConfig{
+		MaxRetries: 1,
+		DialOpts:   []radix.DialOpt{radix.DialReadTimeout(100 * time.Millisecond)},
+	})
+
+	// it may block for a second on top of radix's read timeout
+	var v string
+	if err := conn.Do(retryableredis.Cmd(&v, "BLPOP", "k", "1")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v" {
+		t.Fatalf("BLPOP returned %q, want %q", v, "v")
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if len(cb.retries) > 0 {
+		t.Fatalf("BLPOP was retried: %v", cb.retries)
+	}
+}
@@ -0,0 +1,79 @@
+package retryableredis
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrBudgetExhausted is returned by Do instead of retrying or reconnecting once
+// the RetryBudget has run out of tokens
+var ErrBudgetExhausted = errors.New("retryableredis: retry budget exhausted")
+
+// RetryBudget is a token bucket limiting the rate of retries and reconnect
+// attempts. Sharing a single RetryBudget between all the conns of a process
+// bounds the aggregate retry traffic while the server is down, instead of
+// every conn retrying at its own pace.
+//
+// Every retry and every dial after the first one of a reconnect takes a token,
+// the bucket holds up to burst tokens and is refilled at perSecond tokens per
+// second.
+type RetryBudget struct {
+	perSecond float64
+	burst     float64
+
+	mu     sync.Mutex
+	tokens float64
+	last   time.Time
+}
+
+// NewRetryBudget returns a full RetryBudget that allows bursts of up to burst
+// retries, refilled at perSecond retries per second
+func NewRetryBudget(perSecond float64, burst int) *RetryBudget {
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &RetryBudget{
+		perSecond: perSecond,
+		burst:     float64(burst),
+		tokens:    float64(burst),
+		last:      time.Now(),
+	}
+}
+
+// take takes a token, reporting whether there was one. A nil budget always
+// allows retrying.
+func (b *RetryBudget) take() bool {
+	if b == nil {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.tokens += now.Sub(b.last).Seconds() * b.perSecond
+	if b.tokens > b.burst {
+		b.tokens = b.burst
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Remaining returns the number of retries that can currently be made
+func (b *RetryBudget) Remaining() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	tokens := b.tokens + time.Since(b.last).Seconds()*b.perSecond
+	if tokens > b.burst {
+		tokens = b.burst
+	}
+	return int(tokens)
+}
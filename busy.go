@@ -0,0 +1,45 @@
+package retryableredis
+
+import (
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// busyWait tracks how long a Do call has been getting BUSY errors, killing the
+// running script once BusyScriptKillAfter has passed
+type busyWait struct {
+	since  time.Time
+	killed bool
+}
+
+func (bw *busyWait) retried(conf *DialConfig) {
+	if conf.BusyScriptKillAfter <= 0 || bw.killed {
+		return
+	}
+
+	if bw.since.IsZero() {
+		bw.since = time.Now()
+		return
+	}
+
+	if time.Since(bw.since) >= conf.BusyScriptKillAfter {
+		bw.killed = true
+		err := conf.scriptKill()
+		if err != nil && conf.Logger != nil {
+			conf.Logger.Warn("failed killing busy script", "err", err)
+		}
+	}
+}
+
+// scriptKill sends SCRIPT KILL on a new connection, as the main one is blocked
+// by the script
+func (conf *DialConfig) scriptKill() error {
+	conn, err := conf.dial()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return conn.Do(radix.Cmd(nil, "SCRIPT", "KILL"))
+}
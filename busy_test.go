@@ -0,0 +1,40 @@
+package retryableredis_test
+
+import (
+	"testing"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestBusyRetried(t *testing.T) {
+	s := newFlakyServer(t)
+
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{RetryBusy: true})
+
+	// a script keeps the server busy for the first two attempts
+	s.Proxy.Respond(retryableredistest.Error("BUSY Redis is busy running a script."), 2)
+	if err := conn.Do(retryableredis.Cmd(nil, "SET", "k", "v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Redis.Get("k"); v != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+	if len(cb.retries) != 2 {
+		t.Fatalf("retried %d times, want 2", len(cb.retries))
+	}
+}
+
+func TestBusyNotRetriedByDefault(t *testing.T) {
+	s := newFlakyServer(t)
+
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{})
+
+	s.Proxy.Respond(retryableredistest.Error("BUSY Redis is busy running a script."), 1)
+	err := conn.Do(retryableredis.Cmd(nil, "SET", "k", "v"))
+	if code := retryableredis.ErrorCode(err); code != "BUSY" {
+		t.Fatalf("got %v, want a BUSY error", err)
+	}
+}
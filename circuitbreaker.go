@@ -0,0 +1,128 @@
+package retryableredis
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Do without attempting the action while the
+// circuit breaker is open
+var ErrCircuitOpen = errors.New("retryableredis: circuit breaker is open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+// CircuitBreaker makes Do fail fast with ErrCircuitOpen after too many
+// consecutive failed attempts, instead of every caller sleeping in its own
+// retry loop while the server is down.
+//
+// After OpenDuration has passed it lets up to HalfOpenProbes attempts through,
+// closing again if one of them succeeds and reopening if one fails.
+//
+// A single CircuitBreaker can be shared between multiple conns.
+type CircuitBreaker struct {
+	failureThreshold int
+	openDuration     time.Duration
+	halfOpenProbes   int
+
+	mu       sync.Mutex
+	state    circuitState
+	failures int
+	openedAt time.Time
+	inFlight int
+	// failedAt is when the last attempt failed
+	failedAt time.Time
+}
+
+// NewCircuitBreaker returns a CircuitBreaker that opens after failureThreshold
+// consecutive failures and stays open for openDuration
+func NewCircuitBreaker(failureThreshold int, openDuration time.Duration, halfOpenProbes int) *CircuitBreaker {
+	if failureThreshold < 1 {
+		failureThreshold = 1
+	}
+	if halfOpenProbes < 1 {
+		halfOpenProbes = 1
+	}
+
+	return &CircuitBreaker{
+		failureThreshold: failureThreshold,
+		openDuration:     openDuration,
+		halfOpenProbes:   halfOpenProbes,
+	}
+}
+
+// allow reports whether an attempt may be made, every allowed attempt has to be
+// followed by a call to record
+func (cb *CircuitBreaker) allow() bool {
+	if cb == nil {
+		return true
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	if cb.state == circuitOpen {
+		if time.Since(cb.openedAt) < cb.openDuration {
+			return false
+		}
+
+		cb.state = circuitHalfOpen
+		cb.inFlight = 0
+	}
+
+	if cb.state == circuitHalfOpen {
+		if cb.inFlight >= cb.halfOpenProbes {
+			return false
+		}
+		cb.inFlight++
+	}
+
+	return true
+}
+
+// record records the outcome of an attempt allowed by allow. The failures are
+// only forgotten after a success once none happened for healthyPeriod.
+func (cb *CircuitBreaker) record(failed bool, healthyPeriod time.Duration) {
+	if cb == nil {
+		return
+	}
+
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	switch cb.state {
+	case circuitHalfOpen:
+		cb.inFlight--
+		if failed {
+			cb.trip()
+		} else {
+			cb.state = circuitClosed
+			cb.failures = 0
+		}
+	case circuitClosed:
+		if !failed {
+			if time.Since(cb.failedAt) >= healthyPeriod {
+				cb.failures = 0
+			}
+			return
+		}
+
+		cb.failures++
+		cb.failedAt = time.Now()
+		if cb.failures >= cb.failureThreshold {
+			cb.trip()
+		}
+	}
+}
+
+func (cb *CircuitBreaker) trip() {
+	cb.state = circuitOpen
+	cb.openedAt = time.Now()
+	cb.failures = 0
+}
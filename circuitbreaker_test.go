@@ -0,0 +1,58 @@
+package retryableredis_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+	"github.com/mediocregopher/radix/v3"
+)
+
+func TestCircuitBreakerTxnAborted(t *testing.T) {
+	s := newFlakyServer(t)
+	s.Redis.Set("k", "v")
+	breaker := retryableredis.NewCircuitBreaker(1, 10*time.Millisecond, 1)
+
+	otherProxy := retryableredistest.NewProxy(s.Redis.Addr())
+	defer otherProxy.Close()
+
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{})
+	other := dialProxy(t, otherProxy.Addr(), &cb, retryableredis.DialConfig{CircuitBreaker: breaker})
+
+	// reconnecting in the middle of it aborts the transaction
+	if err := conn.Do(retryableredis.Cmd(nil, "MULTI")); err != nil {
+		t.Fatal(err)
+	}
+	s.Proxy.Break(retryableredistest.FaultDrop)
+	err := conn.Do(retryableredis.Cmd(nil, "SET", "k", "v2"))
+	if !errors.Is(err, retryableredis.ErrTxnAborted) {
+		t.Fatalf("got %v, want ErrTxnAborted", err)
+	}
+	conn.UpdateConfig(func(conf *retryableredis.DialConfig) { conf.CircuitBreaker = breaker })
+
+	// a failure on the other conn opens the shared breaker
+	otherProxy.Break(retryableredistest.FaultDrop)
+	other.Do(retryableredis.Cmd(nil, "GET", "k"))
+	time.Sleep(20 * time.Millisecond)
+
+	// the commands rejected for the aborted transaction don't use up the
+	// half-open probe
+	for _, cmd := range []radix.CmdAction{
+		retryableredis.Cmd(nil, "SET", "k", "v2"),
+		retryableredis.Cmd(nil, "EXEC"),
+	} {
+		if err := conn.Do(cmd); !errors.Is(err, retryableredis.ErrTxnAborted) {
+			t.Fatalf("got %v, want ErrTxnAborted", err)
+		}
+	}
+	var v string
+	if err := conn.Do(retryableredis.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+}
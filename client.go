@@ -0,0 +1,195 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// RetryConfig configures the retrying done by WrapClient, the fields are the
+// same as in DialConfig
+type RetryConfig struct {
+	RetryBackoff        Backoff
+	ErrorBackoffs       map[string]Backoff
+	MaxRetries          int
+	RetryPolicy         RetryPolicy
+	ClassifyError       func(error) ErrorClass
+	RetryOnlyIdempotent bool
+	RetryBudget         *RetryBudget
+
+	RetryableErrorPrefixes []string
+
+	OnRetry     func(err error)
+	OnRetryInfo func(RetryInfo)
+	OnGiveUp    func(cmd string, args []string, err error)
+	Logger      Logger
+
+	RetrySampler        *RetrySampler
+	OnRetriesSuppressed func(n int)
+}
+
+// retryClient is a radix.Client wrapped by WrapClient
+type retryClient struct {
+	radix.Client
+
+	// conf is the RetryConfig as a DialConfig, to share the retry logic
+	conf DialConfig
+}
+
+var _ radix.Client = (*retryClient)(nil)
+
+// WrapClient returns a radix.Client that retries the actions performed on c,
+// e.g a radix.Pool or radix.Cluster made elsewhere. Error replies like LOADING
+// and network errors are retried with a backoff, clients like radix.Pool
+// replace broken conns themselves.
+//
+// Like with Conn only the Cmd and FlatCmd actions from this package, or ones
+// wrapped with Retryable, can be retried.
+func WrapClient(c radix.Client, conf *RetryConfig) radix.Client {
+	return &retryClient{
+		Client: c,
+		conf:   conf.dialConfig(),
+	}
+}
+
+func (conf *RetryConfig) dialConfig() DialConfig {
+	return DialConfig{
+		RetryBackoff:        conf.RetryBackoff,
+		ErrorBackoffs:       conf.ErrorBackoffs,
+		MaxRetries:          conf.MaxRetries,
+		RetryPolicy:         conf.RetryPolicy,
+		ClassifyError:       conf.ClassifyError,
+		RetryOnlyIdempotent: conf.RetryOnlyIdempotent,
+		RetryBudget:         conf.RetryBudget,
+
+		RetryableErrorPrefixes: conf.RetryableErrorPrefixes,
+		OnRetry:                conf.OnRetry,
+		OnRetryInfo:            conf.OnRetryInfo,
+		OnGiveUp:               conf.OnGiveUp,
+		Logger:                 conf.Logger,
+		RetrySampler:           conf.RetrySampler,
+		OnRetriesSuppressed:    conf.OnRetriesSuppressed,
+	}
+}
+
+// Do implements radix.Client
+func (rc *retryClient) Do(a radix.Action) error {
+	return rc.DoContext(context.Background(), a)
+}
+
+// DoContext performs the action, retrying it until ctx is done. ctx isn't
+// passed on to the wrapped client, it only stops the retrying.
+func (rc *retryClient) DoContext(ctx context.Context, a radix.Action) error {
+	a = captureCmd(a)
+	cmds := actionCmdNames(a)
+	if hasSubscribeCmd(cmds) {
+		return ErrUsePubSubAPI
+	}
+	if switchesToRESP3(cmds, a) {
+		return ErrRESP3Unsupported
+	}
+	return rc.conf.retry(ctx, a, func(context.Context) error {
+		return rc.Client.Do(a)
+	})
+}
+
+// RetryFunc calls fn until it succeeds, retrying its errors the same way
+// WrapClient does. cmds and keys are the commands and keys fn acts on, for the
+// callbacks and RetryOnlyIdempotent.
+//
+// It's meant for retrying the clients of other libraries, see the
+// retryableredisv4 package for radix v4.
+func RetryFunc(ctx context.Context, conf *RetryConfig, cmds, keys []string, fn func(context.Context) error) error {
+	dc := conf.dialConfig()
+	return dc.retry(ctx, &funcAction{cmds: cmds, keys: keys}, fn)
+}
+
+// funcAction describes the commands performed by a RetryFunc call, it can't be
+// performed itself
+type funcAction struct {
+	cmds []string
+	keys []string
+}
+
+func (fa *funcAction) Keys() []string {
+	return fa.keys
+}
+
+func (fa *funcAction) Run(radix.Conn) error {
+	return errors.New("retryableredis: RetryFunc action can't be performed")
+}
+
+// retry performs a with fn, retrying it until ctx is done
+func (conf *DialConfig) retry(ctx context.Context, a radix.Action, fn func(context.Context) error) error {
+	cmds := actionCmdNames(a)
+	var args func() [][]string
+	if conf.OnGiveUp != nil {
+		args = giveUpArgs(a)
+	}
+
+	err := conf.retryAttempts(ctx, a, fn)
+	if err != nil {
+		conf.logGiveUp(cmds, err)
+		if gaveUp(err) {
+			conf.onGiveUp(cmds, args, err)
+		}
+	}
+	return err
+}
+
+func (conf *DialConfig) retryAttempts(ctx context.Context, a radix.Action, fn func(context.Context) error) error {
+	policy := conf.retryPolicy()
+	// when the server started responding with LOADING
+	var loadingSince time.Time
+	for retries := 0; ; retries++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		class := conf.classify(err)
+		switch class {
+		case ErrorReconnect:
+			replied := errors.As(err, new(resp2.Error))
+			if conf.RetryOnlyIdempotent && !replied && !isIdempotentAction(a) && !isIdempotencyCmd(a) {
+				return ambiguousErr(a, err)
+			}
+		case ErrorRetryable:
+		default:
+			return err
+		}
+
+		delay, ok := policy.NextDelay(retries, err)
+		if !ok {
+			return &RetriesExhaustedError{Attempts: retries + 1, Err: err}
+		}
+		if class == ErrorRetryable && conf.RetryPolicy == nil {
+			if b, ok := conf.errorBackoff(ErrorCode(err)); ok {
+				delay = b.Delay(retries)
+			}
+		}
+		if isLoadingErr(err) {
+			if loadingSince.IsZero() {
+				loadingSince = time.Now()
+			}
+			// waiting can't succeed after the deadline
+			if pastDeadline(ctx, delay) {
+				return &StillLoadingError{Waited: time.Since(loadingSince), Err: err}
+			}
+		}
+		if !conf.RetryBudget.take() {
+			return ErrBudgetExhausted
+		}
+
+		info := conf.newRetryInfo(ctx, a, err)
+		info.Attempt = retries + 1
+		info.Delay = delay
+		conf.onRetry(info)
+		if err := sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package retryableredis
+
+import (
+	"context"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// DefaultClusterNodeReconnectAttempts is used as MaxReconnectAttempts for the
+// conns to cluster nodes if neither it nor a ReconnectPolicy is set, as a node
+// that's gone might never come back
+const DefaultClusterNodeReconnectAttempts = 3
+
+// ClusterConfig configures a Cluster
+type ClusterConfig struct {
+	// Addrs are the addresses used to discover the cluster topology
+	Addrs []string
+
+	// PoolConfig is the template for the pool to every node, its Network and
+	// Addr are overwritten
+	PoolConfig PoolConfig
+
+	// MaxFailovers is the max number of times an action is retried after
+	// refreshing the topology because the node it was sent to gave up,
+	// defaults to 3
+	MaxFailovers int
+
+	// ClusterOpts are passed on to radix.NewCluster, ClusterPoolFunc is set
+	// already
+	ClusterOpts []radix.ClusterOpt
+}
+
+// Cluster is a radix.Client for redis cluster, using a Pool for every node.
+//
+// MOVED and ASK redirects are followed by the underlying radix.Cluster for the
+// Cmd and FlatCmd actions in this package. When a node gives up reconnecting
+// (e.g. because it was removed during a failover) the topology is refreshed and
+// the action is retried on whichever node now owns the key.
+type Cluster struct {
+	*radix.Cluster
+
+	conf ClusterConfig
+}
+
+var _ radix.Client = (*Cluster)(nil)
+
+// NewCluster connects to the cluster at conf.Addrs
+func NewCluster(conf *ClusterConfig) (*Cluster, error) {
+	c := &Cluster{
+		conf: *conf,
+	}
+	if c.conf.MaxFailovers < 1 {
+		c.conf.MaxFailovers = 3
+	}
+
+	connConf := &c.conf.PoolConfig.ConnConfig
+	if connConf.MaxReconnectAttempts == 0 && connConf.ReconnectPolicy == nil {
+		connConf.MaxReconnectAttempts = DefaultClusterNodeReconnectAttempts
+	}
+
+	opts := append([]radix.ClusterOpt{radix.ClusterPoolFunc(c.newPool)}, c.conf.ClusterOpts...)
+	inner, err := radix.NewCluster(c.conf.Addrs, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Cluster = inner
+	return c, nil
+}
+
+func (c *Cluster) newPool(network, addr string) (radix.Client, error) {
+	conf := c.conf.PoolConfig
+	conf.Network = network
+	conf.Addr = addr
+	return NewPool(&conf)
+}
+
+// Do implements radix.Client
+func (c *Cluster) Do(a radix.Action) error {
+	return c.DoContext(context.Background(), a)
+}
+
+// DoContext performs the action on the node owning its keys, it stops
+// failing over to other nodes once ctx is done.
+func (c *Cluster) DoContext(ctx context.Context, a radix.Action) error {
+	for failovers := 0; ; failovers++ {
+		err := c.Cluster.Do(a)
+		if !connBroken(err) || failovers >= c.conf.MaxFailovers {
+			return err
+		}
+
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return err
+		}
+
+		// the node might be gone, refresh the topology and try again
+		if syncErr := c.Cluster.Sync(); syncErr != nil {
+			return err
+		}
+	}
+}
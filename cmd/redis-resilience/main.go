@@ -0,0 +1,238 @@
+// Command redis-resilience hammers a redis server with a mix of commands
+// through a retryableredis conn, printing retry, reconnect and error
+// statistics. Run it while restarting or failing over redis to see how a
+// config holds up:
+//
+//	redis-resilience -addr localhost:6379 -mix GET=70,SET=25,INCR=5 -workers 8
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+)
+
+// commands are the commands that can be used in the mix, they're called with
+// a random key
+var commands = map[string]func(key string) []string{
+	"GET":   func(key string) []string { return []string{"GET", key} },
+	"SET":   func(key string) []string { return []string{"SET", key, strconv.Itoa(rand.Int())} },
+	"INCR":  func(key string) []string { return []string{"INCR", "counter:" + key} },
+	"DEL":   func(key string) []string { return []string{"DEL", key} },
+	"LPUSH": func(key string) []string { return []string{"LPUSH", "list:" + key, "x"} },
+	"RPOP":  func(key string) []string { return []string{"RPOP", "list:" + key} },
+	"HSET":  func(key string) []string { return []string{"HSET", "hash:" + key, "f", "v"} },
+	"HGET":  func(key string) []string { return []string{"HGET", "hash:" + key, "f"} },
+}
+
+type mixEntry struct {
+	cmd    string
+	weight int
+}
+
+// parseMix parses a mix like "GET=70,SET=30"
+func parseMix(s string) ([]mixEntry, int, error) {
+	var mix []mixEntry
+	total := 0
+	for _, part := range strings.Split(s, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		cmd := strings.ToUpper(strings.TrimSpace(kv[0]))
+		if _, ok := commands[cmd]; !ok {
+			return nil, 0, fmt.Errorf("unsupported command %q", cmd)
+		}
+
+		weight := 1
+		if len(kv) == 2 {
+			var err error
+			if weight, err = strconv.Atoi(kv[1]); err != nil || weight < 0 {
+				return nil, 0, fmt.Errorf("invalid weight for %s: %q", cmd, kv[1])
+			}
+		}
+		mix = append(mix, mixEntry{cmd: cmd, weight: weight})
+		total += weight
+	}
+
+	if total < 1 {
+		return nil, 0, fmt.Errorf("mix has no weight")
+	}
+	return mix, total, nil
+}
+
+func pick(mix []mixEntry, total int) string {
+	n := rand.Intn(total)
+	for _, e := range mix {
+		if n < e.weight {
+			return e.cmd
+		}
+		n -= e.weight
+	}
+	return mix[len(mix)-1].cmd
+}
+
+// errCounts counts the errors returned by Do by message
+type errCounts struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (ec *errCounts) add(err error) {
+	msg := err.Error()
+	if len(msg) > 80 {
+		msg = msg[:80] + "..."
+	}
+
+	ec.mu.Lock()
+	ec.counts[msg]++
+	ec.mu.Unlock()
+}
+
+// take returns the counts and resets them
+func (ec *errCounts) take() map[string]int {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	counts := ec.counts
+	ec.counts = map[string]int{}
+	return counts
+}
+
+func main() {
+	var (
+		network        = flag.String("network", "tcp", "network of the server")
+		addr           = flag.String("addr", "localhost:6379", "address of the server")
+		mixFlag        = flag.String("mix", "GET=70,SET=25,INCR=5", "commands to send and their weights")
+		workers        = flag.Int("workers", 4, "number of concurrent workers")
+		keys           = flag.Int("keys", 1000, "number of distinct keys")
+		duration       = flag.Duration("duration", 0, "how long to run, 0 runs until interrupted")
+		interval       = flag.Duration("interval", time.Second, "how often to print statistics")
+		maxRetries     = flag.Int("max-retries", 0, "DialConfig.MaxRetries")
+		commandTimeout = flag.Duration("command-timeout", 0, "DialConfig.CommandTimeout")
+		onlyIdempotent = flag.Bool("only-idempotent", false, "DialConfig.RetryOnlyIdempotent")
+		autoPipeline   = flag.Duration("auto-pipeline", 0, "DialConfig.AutoPipelineWindow")
+	)
+	flag.Parse()
+
+	mix, total, err := parseMix(*mixFlag)
+	if err != nil {
+		log.Fatalf("-mix: %v", err)
+	}
+
+	conn, err := retryableredis.Dial(&retryableredis.DialConfig{
+		Network:             *network,
+		Addr:                *addr,
+		MaxRetries:          *maxRetries,
+		CommandTimeout:      *commandTimeout,
+		RetryOnlyIdempotent: *onlyIdempotent,
+		AutoPipelineWindow:  *autoPipeline,
+		OnStateChange: func(old, new retryableredis.State, cause error) {
+			log.Printf("state %s -> %s (%v)", old, new, cause)
+		},
+	})
+	if err != nil {
+		log.Fatalf("dialing: %v", err)
+	}
+	defer conn.Close()
+
+	stop := make(chan struct{})
+	var ops, failed uint64
+	errs := &errCounts{counts: map[string]int{}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < *workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				args := commands[pick(mix, total)](strconv.Itoa(rand.Intn(*keys)))
+				err := conn.Do(retryableredis.Cmd(nil, args[0], args[1:]...))
+				atomic.AddUint64(&ops, 1)
+				if err != nil {
+					atomic.AddUint64(&failed, 1)
+					errs.add(err)
+				}
+			}
+		}()
+	}
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt)
+	var deadline <-chan time.Time
+	if *duration > 0 {
+		deadline = time.After(*duration)
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+	started := time.Now()
+	var prev retryableredis.Stats
+	var prevOps uint64
+
+loop:
+	for {
+		select {
+		case <-ticker.C:
+			st := conn.Stats()
+			curOps := atomic.LoadUint64(&ops)
+			printStats(*interval, curOps-prevOps, st, prev, conn.State(), errs.take())
+			prev, prevOps = st, curOps
+		case <-sigCh:
+			break loop
+		case <-deadline:
+			break loop
+		}
+	}
+
+	close(stop)
+	wg.Wait()
+
+	st := conn.Stats()
+	fmt.Printf("\ntotal: %d ops in %s, %d failed, %d retries, %d reconnects\n",
+		atomic.LoadUint64(&ops), time.Since(started).Round(time.Millisecond),
+		atomic.LoadUint64(&failed), st.Retries, st.Reconnects)
+	printLatencies(st)
+}
+
+func printStats(interval time.Duration, ops uint64, st, prev retryableredis.Stats, state retryableredis.State, errs map[string]int) {
+	fmt.Printf("%s  %8.0f ops/s  retries %d  reconnects %d  state %s\n",
+		time.Now().Format("15:04:05"), float64(ops)/interval.Seconds(),
+		st.Retries-prev.Retries, st.Reconnects-prev.Reconnects, state)
+
+	msgs := make([]string, 0, len(errs))
+	for msg := range errs {
+		msgs = append(msgs, msg)
+	}
+	sort.Strings(msgs)
+	for _, msg := range msgs {
+		fmt.Printf("          %6d x %s\n", errs[msg], msg)
+	}
+}
+
+func printLatencies(st retryableredis.Stats) {
+	cmds := make([]string, 0, len(st.Latency))
+	for cmd := range st.Latency {
+		cmds = append(cmds, cmd)
+	}
+	sort.Strings(cmds)
+
+	fmt.Printf("%-8s %10s %10s %10s %10s\n", "cmd", "count", "p50", "p99", "max")
+	for _, cmd := range cmds {
+		h := st.Latency[cmd]
+		fmt.Printf("%-8s %10d %10s %10s %10s\n", cmd, h.Count, h.Quantile(0.5), h.Quantile(0.99), h.Max)
+	}
+}
@@ -0,0 +1,59 @@
+package retryableredis
+
+import (
+	"sync"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+var (
+	cmdPool = sync.Pool{
+		New: func() interface{} { return new(RetryableCmd) },
+	}
+	flatCmdPool = sync.Pool{
+		New: func() interface{} { return new(RetryableFlatCmd) },
+	}
+)
+
+// Release puts the cmd back into the pool used by Cmd to save allocations, it
+// must not be used anymore afterwards
+func (r *RetryableCmd) Release() {
+	*r = RetryableCmd{}
+	cmdPool.Put(r)
+}
+
+// Release puts the cmd back into the pool used by FlatCmd to save
+// allocations, it must not be used anymore afterwards
+func (r *RetryableFlatCmd) Release() {
+	*r = RetryableFlatCmd{}
+	flatCmdPool.Put(r)
+}
+
+// Release releases a if it was made with Cmd or FlatCmd, see
+// RetryableCmd.Release
+func Release(a radix.Action) {
+	switch r := a.(type) {
+	case *RetryableCmd:
+		r.Release()
+	case *RetryableFlatCmd:
+		r.Release()
+	case wrappedCmd:
+		Release(r.unwrapCmd())
+	}
+}
+
+// DoCmd performs a Cmd on c and releases it afterwards
+func DoCmd(c radix.Client, rcv interface{}, cmd string, args ...string) error {
+	a := Cmd(rcv, cmd, args...)
+	err := c.Do(a)
+	Release(a)
+	return err
+}
+
+// DoFlatCmd performs a FlatCmd on c and releases it afterwards
+func DoFlatCmd(c radix.Client, rcv interface{}, cmd, key string, args ...interface{}) error {
+	a := FlatCmd(rcv, cmd, key, args...)
+	err := c.Do(a)
+	Release(a)
+	return err
+}
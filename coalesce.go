@@ -0,0 +1,121 @@
+package retryableredis
+
+import (
+	"bufio"
+	"context"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// coalescableCommands are the commands deduplicated by CoalesceReads, reads
+// whose result only depends on the data
+var coalescableCommands = map[string]bool{
+	"EXISTS": true, "GET": true, "HGET": true, "HGETALL": true, "HMGET": true,
+	"LRANGE": true, "MGET": true, "SMEMBERS": true, "ZRANGE": true,
+	"ZSCORE": true,
+}
+
+// coalescer deduplicates identical concurrent reads, see
+// DialConfig.CoalesceReads
+type coalescer struct {
+	mu    sync.Mutex
+	calls map[string]*coalescedCall
+}
+
+type coalescedCall struct {
+	done chan struct{}
+
+	reply    coalescedReply
+	attempts int
+	err      error
+}
+
+// coalesceKey returns the key identifying an action made with Cmd or FlatCmd
+// for a coalescable command, its args and its receiver
+func coalesceKey(a radix.Action) (string, []string, interface{}, bool) {
+	var rcv interface{}
+	switch v := a.(type) {
+	case *RetryableCmd:
+		rcv = v.rcv
+	case *RetryableFlatCmd:
+		rcv = v.rcv
+	default:
+		return "", nil, nil, false
+	}
+
+	if !coalescableCommands[strings.ToUpper(actionCmdNames(a)[0])] {
+		return "", nil, nil, false
+	}
+
+	cmds := actionArgs(a)
+	if len(cmds) != 1 {
+		return "", nil, nil, false
+	}
+	args := cmds[0]
+
+	var key strings.Builder
+	key.WriteString(strings.ToUpper(args[0]))
+	for _, arg := range args[1:] {
+		key.WriteByte(' ')
+		key.WriteString(strconv.Itoa(len(arg)))
+		key.WriteByte(':')
+		key.WriteString(arg)
+	}
+	return key.String(), args, rcv, true
+}
+
+// do performs the cmd args with do, unless an identical call is in flight
+// already in which case its reply is used. The reply is unmarshaled into rcv.
+func (co *coalescer) do(ctx context.Context, key string, args []string, rcv interface{}, do func(context.Context, radix.Action) (int, error)) (int, error) {
+	co.mu.Lock()
+	call, ok := co.calls[key]
+	if !ok {
+		if co.calls == nil {
+			co.calls = map[string]*coalescedCall{}
+		}
+		call = &coalescedCall{done: make(chan struct{})}
+		co.calls[key] = call
+
+		go func() {
+			// it's shared by all the callers, so none of them can cancel it
+			call.attempts, call.err = do(context.Background(), Cmd(&call.reply, args[0], args[1:]...))
+
+			co.mu.Lock()
+			delete(co.calls, key)
+			co.mu.Unlock()
+			close(call.done)
+		}()
+	}
+	co.mu.Unlock()
+
+	select {
+	case <-call.done:
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	}
+
+	if call.err != nil {
+		return call.attempts, call.err
+	}
+	if rcv == nil {
+		return call.attempts, nil
+	}
+	return call.attempts, call.reply.raw.UnmarshalInto(resp2.Any{I: rcv})
+}
+
+// coalescedReply holds the raw reply to a coalesced call, error replies are
+// returned as errors so they're retried like for any other cmd
+type coalescedReply struct {
+	raw resp2.RawMessage
+}
+
+func (r *coalescedReply) UnmarshalRESP(br *bufio.Reader) error {
+	if b, err := br.Peek(1); err == nil && b[0] == resp2.ErrorPrefix[0] {
+		return (resp2.Any{}).UnmarshalRESP(br)
+	}
+	return r.raw.UnmarshalRESP(br)
+}
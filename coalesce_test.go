@@ -0,0 +1,55 @@
+package retryableredis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestCoalescedReadContext(t *testing.T) {
+	for _, conf := range []retryableredis.DialConfig{
+		{CoalesceReads: true},
+		{CoalesceReads: true, StaleCache: retryableredis.NewStaleCache(10, 0)},
+	} {
+		srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+			return retryableredistest.Value("v").After(200 * time.Millisecond)
+		})
+		defer srv.Close()
+		var cb callbacks
+		conn := dialProxy(t, srv.Addr(), &cb, conf)
+
+		leader := make(chan error, 1)
+		var v string
+		go func() {
+			leader <- conn.Do(retryableredis.Cmd(&v, "GET", "k"))
+		}()
+		time.Sleep(10 * time.Millisecond)
+
+		// the caller sharing the read gives up on it once its ctx is done,
+		// the read goes on for the other one
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		started := time.Now()
+		err := conn.DoContext(ctx, retryableredis.Cmd(nil, "GET", "k"))
+		cancel()
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("got %v, want context.DeadlineExceeded", err)
+		}
+		if took := time.Since(started); took > 100*time.Millisecond {
+			t.Fatalf("returned after %v, the ctx was done after 20ms", took)
+		}
+
+		if err := <-leader; err != nil {
+			t.Fatal(err)
+		}
+		if v != "v" {
+			t.Fatalf("got %q, want %q", v, "v")
+		}
+		if cmds := srv.Commands(); len(cmds) != 1 {
+			t.Fatalf("sent %q, want a single GET", cmds)
+		}
+	}
+}
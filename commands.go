@@ -0,0 +1,243 @@
+package retryableredis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// ErrRESP3Unsupported is returned by Do for HELLO 3, as radix v3 only speaks
+// RESP2. RESP3 is supported by the retryableredisv4 package, through the
+// Protocol of radix v4's Dialer.
+var ErrRESP3Unsupported = errors.New("retryableredis: RESP3 needs radix v4, use retryableredisv4")
+
+// ErrAmbiguousResult is matched by the errors returned when a command that
+// isn't safe to retry failed in a way where it may or may not have been
+// executed, check for it using errors.Is(err, ErrAmbiguousResult)
+var ErrAmbiguousResult = errors.New("retryableredis: ambiguous result")
+
+// AmbiguousResultError is returned instead of retrying a command after a
+// network error, as it might have been executed already. See
+// DialConfig.RetryOnlyIdempotent and DialConfig.DeliveryMode.
+type AmbiguousResultError struct {
+	// Cmd is the name of the command, empty if it couldn't be determined
+	Cmd string
+
+	// Err is the network error
+	Err error
+}
+
+func (e *AmbiguousResultError) Error() string {
+	return fmt.Sprintf("retryableredis: %s may or may not have been executed: %v", e.Cmd, e.Err)
+}
+
+func (e *AmbiguousResultError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrAmbiguousResult) match
+func (e *AmbiguousResultError) Is(target error) bool {
+	return target == ErrAmbiguousResult
+}
+
+// readOnlyCommands don't modify any data
+var readOnlyCommands = map[string]bool{
+	"BITCOUNT": true, "BITPOS": true, "DBSIZE": true, "DUMP": true,
+	"ECHO": true, "EXISTS": true, "GEODIST": true, "GEOHASH": true,
+	"GEOPOS": true, "GEORADIUS_RO": true, "GEORADIUSBYMEMBER_RO": true,
+	"GEOSEARCH": true, "GET": true, "GETBIT": true, "GETRANGE": true,
+	"HEXISTS": true, "HGET": true, "HGETALL": true, "HKEYS": true,
+	"HLEN": true, "HMGET": true, "HRANDFIELD": true, "HSCAN": true,
+	"HSTRLEN": true, "HVALS": true, "KEYS": true, "LINDEX": true,
+	"LLEN": true, "LPOS": true, "LRANGE": true, "MGET": true,
+	"PFCOUNT": true, "PING": true, "PTTL": true, "RANDOMKEY": true,
+	"SCAN": true, "SCARD": true, "SDIFF": true, "SINTER": true,
+	"SISMEMBER": true, "SMEMBERS": true, "SMISMEMBER": true,
+	"SRANDMEMBER": true, "SSCAN": true, "STRLEN": true, "SUNION": true,
+	"TIME": true, "TTL": true, "TYPE": true, "XINFO": true, "XLEN": true,
+	"XPENDING": true, "XRANGE": true, "XREAD": true, "XREVRANGE": true,
+	"ZCARD": true, "ZCOUNT": true, "ZLEXCOUNT": true, "ZMSCORE": true,
+	"ZRANDMEMBER": true, "ZRANGE": true, "ZRANGEBYLEX": true,
+	"ZRANGEBYSCORE": true, "ZRANK": true, "ZREVRANGE": true,
+	"ZREVRANGEBYLEX": true, "ZREVRANGEBYSCORE": true, "ZREVRANK": true,
+	"ZSCAN": true, "ZSCORE": true,
+}
+
+// idempotentCommands modify data, but running them twice has the same effect
+// as running them once
+var idempotentCommands = map[string]bool{
+	"DEL": true, "EXPIRE": true, "EXPIREAT": true, "GEOADD": true,
+	"HDEL": true, "HMSET": true, "HSET": true, "MSET": true,
+	"PERSIST": true, "PEXPIRE": true, "PEXPIREAT": true, "PFADD": true,
+	"PSETEX": true, "SADD": true, "SET": true, "SETBIT": true,
+	"SETEX": true, "SETRANGE": true, "SREM": true, "UNLINK": true,
+	"ZREM": true, "ZREMRANGEBYLEX": true, "ZREMRANGEBYRANK": true,
+	"ZREMRANGEBYSCORE": true,
+}
+
+// IsReadOnlyCommand reports whether cmd is known not to modify any data
+func IsReadOnlyCommand(cmd string) bool {
+	return readOnlyCommands[strings.ToUpper(cmd)]
+}
+
+// IsIdempotentCommand reports whether cmd is known to be safe to run more than
+// once, either because it's read only or because running it again has no
+// further effect
+func IsIdempotentCommand(cmd string) bool {
+	cmd = strings.ToUpper(cmd)
+	return readOnlyCommands[cmd] || idempotentCommands[cmd]
+}
+
+// radixCmdType is the type of the CmdActions made by radix.Cmd and
+// radix.FlatCmd
+var radixCmdType = reflect.TypeOf(radix.Cmd(nil, ""))
+
+// capturedCmd is a cmd made by radix.Cmd or radix.FlatCmd, marshaled once when
+// it's passed to Do. Every attempt writes those bytes, so it can be inspected
+// without marshaling it again, and args that are read while marshaling, like
+// a resp.LenReader, are only read once.
+type capturedCmd struct {
+	radix.CmdAction
+	raw  []byte
+	args []string
+	// err is the error marshaling the cmd failed with, returned by Run
+	err error
+}
+
+// captureCmd returns a with a cmd made by radix replaced by a capturedCmd.
+// Other actions are returned as they are.
+func captureCmd(a radix.Action) radix.Action {
+	if ra, ok := a.(*retryAction); ok {
+		if cmd := captureCmd(ra.Action); cmd != ra.Action {
+			return &retryAction{Action: cmd, opts: ra.opts}
+		}
+		return a
+	}
+
+	cmd, ok := a.(radix.CmdAction)
+	if !ok || reflect.TypeOf(a) != radixCmdType {
+		return a
+	}
+
+	buf := new(bytes.Buffer)
+	if err := cmd.MarshalRESP(buf); err != nil {
+		return &capturedCmd{CmdAction: cmd, err: err}
+	}
+	c := &capturedCmd{CmdAction: cmd, raw: buf.Bytes()}
+	if cmds := parseArgs(c.raw); len(cmds) == 1 {
+		c.args = cmds[0]
+	}
+	return c
+}
+
+func (c *capturedCmd) MarshalRESP(w io.Writer) error {
+	_, err := w.Write(c.raw)
+	return err
+}
+
+func (c *capturedCmd) Run(conn radix.Conn) error {
+	if c.err != nil {
+		return c.err
+	}
+	if err := conn.Encode(c); err != nil {
+		return err
+	}
+	return conn.Decode(c.CmdAction)
+}
+
+// ClusterCanRetry implements radix.ClusterCanRetryAction, like radix's own
+// cmds do
+func (c *capturedCmd) ClusterCanRetry() bool {
+	return true
+}
+
+// actionCmdNames returns the names of the commands the action performs, or nil
+// if they can't be determined. Only the actions of this package and the cmds
+// captured by captureCmd can be inspected.
+func actionCmdNames(a radix.Action) []string {
+	switch v := a.(type) {
+	case *RetryableCmd:
+		return []string{v.cmd}
+	case *RetryableFlatCmd:
+		return []string{v.cmd}
+	case *retryAction:
+		return actionCmdNames(v.Action)
+	case *retryableAction:
+		return actionCmdNames(v.newAction())
+	case *funcAction:
+		return v.cmds
+	case *capturedCmd:
+		if len(v.args) < 1 {
+			return nil
+		}
+		return v.args[:1]
+	case wrappedCmd:
+		return actionCmdNames(v.unwrapCmd())
+	case *pipeline:
+		names := make([]string, 0, len(v.cmds))
+		for _, cmd := range v.cmds {
+			cmdNames := actionCmdNames(cmd)
+			if len(cmdNames) < 1 {
+				return nil
+			}
+			names = append(names, cmdNames...)
+		}
+		return names
+	}
+
+	// other actions aren't marshaled to find out, that could read their
+	// args before they're sent
+	return nil
+}
+
+// isIdempotentAction reports whether all the commands in the action are safe
+// to run more than once, actions that can't be inspected are assumed not to be
+func isIdempotentAction(a radix.Action) bool {
+	names := actionCmdNames(a)
+	if len(names) < 1 {
+		return false
+	}
+
+	for _, name := range names {
+		if !IsIdempotentCommand(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// isReadOnlyAction reports whether none of the commands in the action modify
+// any data, actions that can't be inspected are assumed to modify some
+func isReadOnlyAction(a radix.Action) bool {
+	names := actionCmdNames(a)
+	if len(names) < 1 {
+		return false
+	}
+
+	for _, name := range names {
+		if !IsReadOnlyCommand(name) {
+			return false
+		}
+	}
+	return true
+}
+
+// switchesToRESP3 reports whether a has a HELLO switching the connection to
+// RESP3, whose replies radix v3 can't read. cmds are the names of its commands.
+func switchesToRESP3(cmds []string, a radix.Action) bool {
+	if !hasCmd(cmds, "HELLO") {
+		return false
+	}
+
+	for _, args := range actionArgs(a) {
+		if strings.EqualFold(args[0], "HELLO") && len(args) > 1 && args[1] == "3" {
+			return true
+		}
+	}
+	return false
+}
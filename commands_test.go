@@ -0,0 +1,99 @@
+package retryableredis_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+func TestHelloRESP3Rejected(t *testing.T) {
+	srv := retryableredistest.NewServer(nil)
+	defer srv.Close()
+
+	var cb callbacks
+	conn := dialProxy(t, srv.Addr(), &cb, retryableredis.DialConfig{})
+
+	// the conn couldn't read the replies anymore
+	err := conn.Do(retryableredis.Cmd(nil, "HELLO", "3"))
+	if !errors.Is(err, retryableredis.ErrRESP3Unsupported) {
+		t.Fatalf("got %v, want ErrRESP3Unsupported", err)
+	}
+	for _, cmd := range srv.Commands() {
+		if cmd[0] == "HELLO" {
+			t.Fatalf("sent %q", cmd)
+		}
+	}
+
+	if err := conn.Do(retryableredis.Cmd(nil, "HELLO", "2")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRadixCmdLenReader(t *testing.T) {
+	m := miniredis.RunT(t)
+	var cb callbacks
+	conn := dialProxy(t, m.Addr(), &cb, retryableredis.DialConfig{})
+
+	// inspecting the cmds mustn't read their args before they're sent
+	value := func() resp.LenReader {
+		return resp.NewLenReader(strings.NewReader("hello"), 5)
+	}
+	if err := conn.Do(radix.FlatCmd(nil, "SET", "a", value())); err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Do(retryableredis.Pipeline(radix.FlatCmd(nil, "SET", "b", value()))); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		if got, _ := m.Get(key); got != "hello" {
+			t.Fatalf("%s is %q, want %q", key, got, "hello")
+		}
+	}
+}
+
+func TestRadixCmdLenReaderRetried(t *testing.T) {
+	s := newFlakyServer(t)
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{})
+
+	// the retry sends the value read for the first attempt again
+	s.Proxy.Respond(retryableredistest.Loading(), 1)
+	value := resp.NewLenReader(strings.NewReader("hello"), 5)
+	if err := conn.Do(radix.FlatCmd(nil, "SET", "k", value)); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := s.Redis.Get("k"); got != "hello" {
+		t.Fatalf("got %q, want %q", got, "hello")
+	}
+	if len(cb.retries) != 1 {
+		t.Fatalf("retried %d times, want 1", len(cb.retries))
+	}
+}
+
+// foreignCmd is an action of some other package which can't be inspected
+type foreignCmd struct {
+	radix.CmdAction
+}
+
+func TestForeignCmdLenReader(t *testing.T) {
+	m := miniredis.RunT(t)
+	var cb callbacks
+	conn := dialProxy(t, m.Addr(), &cb, retryableredis.DialConfig{})
+
+	// classifying it mustn't marshal it, that'd read the arg
+	value := resp.NewLenReader(strings.NewReader("hello"), 5)
+	if err := conn.Do(foreignCmd{radix.FlatCmd(nil, "SET", "a", value)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if got, _ := m.Get("a"); got != "hello" {
+		t.Fatalf("a is %q, want %q", got, "hello")
+	}
+}
@@ -0,0 +1,342 @@
+package retryableredis
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// DefaultClaimMinIdle is the default ConsumerGroupOpts.ClaimMinIdle
+const DefaultClaimMinIdle = time.Minute
+
+// ConsumerGroupOpts are the options for NewConsumerGroup
+type ConsumerGroupOpts struct {
+	// Streams are the streams to consume, Group and Consumer the names of the
+	// consumer group and this consumer in it
+	Streams  []string
+	Group    string
+	Consumer string
+
+	// CreateGroup creates the group (and the streams) if they don't exist
+	// yet, starting at the end of the streams
+	CreateGroup bool
+
+	// ClaimMinIdle is how long an entry has to be pending for another
+	// consumer before it's claimed, defaults to DefaultClaimMinIdle
+	ClaimMinIdle time.Duration
+
+	// Count, Block and NoBlock are used for XREADGROUP, see
+	// radix.StreamReaderOpts
+	Count   int
+	Block   time.Duration
+	NoBlock bool
+
+	// OnReconnect is called when the conn reconnected while consuming, see
+	// StreamConsumerOpts
+	OnReconnect func()
+}
+
+// ConsumerGroup consumes streams as part of a consumer group. On start and
+// after every reconnect it claims the entries left pending by consumers that
+// are gone (e.g. the previous process or connection using the same name),
+// before reading new ones with a StreamConsumer.
+//
+// Every command is run through the client, so they are retried if it's a Conn
+// or Pool.
+type ConsumerGroup struct {
+	client radix.Client
+	opts   ConsumerGroupOpts
+	sc     *StreamConsumer
+
+	// claim holds the streams to claim pending entries from, and cursors
+	// where to carry on claiming
+	claim   []string
+	cursors map[string]string
+
+	// noAutoClaim is set if the server doesn't support XAUTOCLAIM
+	noAutoClaim bool
+}
+
+// NewConsumerGroup returns a ConsumerGroup reading from c, creating the group
+// first if opts.CreateGroup is set
+func NewConsumerGroup(c radix.Client, opts ConsumerGroupOpts) (*ConsumerGroup, error) {
+	if opts.ClaimMinIdle <= 0 {
+		opts.ClaimMinIdle = DefaultClaimMinIdle
+	}
+
+	cg := &ConsumerGroup{
+		client:  c,
+		opts:    opts,
+		cursors: make(map[string]string, len(opts.Streams)),
+	}
+
+	if opts.CreateGroup {
+		for _, stream := range opts.Streams {
+			err := c.Do(Cmd(nil, "XGROUP", "CREATE", stream, opts.Group, "$", "MKSTREAM"))
+			if err != nil && ErrorCode(err) != "BUSYGROUP" {
+				return nil, err
+			}
+		}
+	}
+
+	streams := make(map[string]*radix.StreamEntryID, len(opts.Streams))
+	for _, stream := range opts.Streams {
+		streams[stream] = nil
+	}
+
+	cg.sc = NewStreamConsumer(c, StreamConsumerOpts{
+		StreamReaderOpts: radix.StreamReaderOpts{
+			Streams:  streams,
+			Group:    opts.Group,
+			Consumer: opts.Consumer,
+			Count:    opts.Count,
+			Block:    opts.Block,
+			NoBlock:  opts.NoBlock,
+		},
+		OnReconnect: cg.onReconnect,
+	})
+
+	cg.startClaim()
+	return cg, nil
+}
+
+func (cg *ConsumerGroup) onReconnect() {
+	cg.startClaim()
+	if cg.opts.OnReconnect != nil {
+		cg.opts.OnReconnect()
+	}
+}
+
+// startClaim makes the next calls to Next claim the pending entries from the
+// start of every stream
+func (cg *ConsumerGroup) startClaim() {
+	cg.claim = append(cg.claim[:0], cg.opts.Streams...)
+	for _, stream := range cg.opts.Streams {
+		cg.cursors[stream] = "0-0"
+	}
+}
+
+// Next returns the next entries from one of the streams, claimed ones first,
+// see StreamConsumer.Next. The entries have to be acknowledged with Ack once
+// they are processed.
+func (cg *ConsumerGroup) Next() (stream string, entries []radix.StreamEntry, err error) {
+	for len(cg.claim) > 0 {
+		stream = cg.claim[0]
+		entries, err = cg.claimNext(stream)
+		if err != nil {
+			return "", nil, err
+		}
+		if len(entries) > 0 {
+			return stream, entries, nil
+		}
+	}
+
+	return cg.sc.Next()
+}
+
+// claimNext claims the next batch of pending entries from stream, moving on
+// to the next stream once all of them are claimed
+func (cg *ConsumerGroup) claimNext(stream string) ([]radix.StreamEntry, error) {
+	var entries []radix.StreamEntry
+	var err error
+	if cg.noAutoClaim {
+		entries, err = cg.pendingClaim(stream)
+	} else {
+		entries, err = cg.autoClaim(stream)
+		if err != nil && isUnknownCmdErr(err) {
+			cg.noAutoClaim = true
+			entries, err = cg.pendingClaim(stream)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if cg.cursors[stream] == "0-0" {
+		cg.claim = cg.claim[1:]
+	}
+	return entries, nil
+}
+
+// autoClaim claims entries with XAUTOCLAIM, available since redis 6.2
+func (cg *ConsumerGroup) autoClaim(stream string) ([]radix.StreamEntry, error) {
+	args := []string{stream, cg.opts.Group, cg.opts.Consumer,
+		strconv.FormatInt(int64(cg.opts.ClaimMinIdle/time.Millisecond), 10), cg.cursors[stream]}
+	if cg.opts.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(cg.opts.Count))
+	}
+
+	var reply autoClaimReply
+	if err := cg.client.Do(Cmd(&reply, "XAUTOCLAIM", args...)); err != nil {
+		return nil, err
+	}
+
+	cg.cursors[stream] = reply.cursor
+	return reply.entries, nil
+}
+
+// pendingClaim claims entries with XPENDING and XCLAIM, for servers without
+// XAUTOCLAIM
+func (cg *ConsumerGroup) pendingClaim(stream string) ([]radix.StreamEntry, error) {
+	count := cg.opts.Count
+	if count < 1 {
+		count = 100
+	}
+
+	start := cg.cursors[stream]
+	if start != "0-0" {
+		// the cursor is the last entry checked, the range is inclusive
+		start = nextEntryID(start)
+	}
+
+	var pending []pendingEntry
+	err := cg.client.Do(Cmd(&pending, "XPENDING", stream, cg.opts.Group, start, "+", strconv.Itoa(count)))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(pending) < count {
+		cg.cursors[stream] = "0-0"
+	} else {
+		cg.cursors[stream] = pending[len(pending)-1].id
+	}
+
+	minIdle := int64(cg.opts.ClaimMinIdle / time.Millisecond)
+	args := []string{stream, cg.opts.Group, cg.opts.Consumer, strconv.FormatInt(minIdle, 10)}
+	for _, p := range pending {
+		if p.idle >= minIdle {
+			args = append(args, p.id)
+		}
+	}
+	if len(args) == 4 {
+		return nil, nil
+	}
+
+	var entries claimedEntries
+	if err := cg.client.Do(Cmd(&entries, "XCLAIM", args...)); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// Ack acknowledges entries returned by Next
+func (cg *ConsumerGroup) Ack(stream string, ids ...radix.StreamEntryID) error {
+	args := []string{stream, cg.opts.Group}
+	for _, id := range ids {
+		args = append(args, id.String())
+	}
+	return cg.client.Do(Cmd(nil, "XACK", args...))
+}
+
+// isUnknownCmdErr reports whether err is the error reply for a command the
+// server doesn't know
+func isUnknownCmdErr(err error) bool {
+	return ErrorCode(err) == "ERR" && strings.Contains(err.Error(), "unknown command")
+}
+
+// autoClaimReply is the reply to XAUTOCLAIM
+type autoClaimReply struct {
+	cursor  string
+	entries []radix.StreamEntry
+}
+
+func (r *autoClaimReply) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N < 2 {
+		return errors.New("retryableredis: invalid xautoclaim reply")
+	}
+
+	var cursor resp2.BulkString
+	if err := cursor.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	r.cursor = cursor.S
+
+	var entries claimedEntries
+	if err := entries.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	r.entries = entries
+
+	// redis 7 adds the IDs of deleted entries
+	for i := 2; i < ah.N; i++ {
+		if err := (resp2.Any{}).UnmarshalRESP(br); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// claimedEntries are the entries returned by XCLAIM and XAUTOCLAIM, skipping
+// the nil ones returned for deleted entries before redis 7
+type claimedEntries []radix.StreamEntry
+
+func (ce *claimedEntries) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	*ce = make(claimedEntries, 0, ah.N)
+	for i := 0; i < ah.N; i++ {
+		if b, err := br.Peek(3); err == nil && string(b) == "*-1" {
+			var nilEntry resp2.ArrayHeader
+			if err := nilEntry.UnmarshalRESP(br); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var entry radix.StreamEntry
+		if err := entry.UnmarshalRESP(br); err != nil {
+			return err
+		}
+		*ce = append(*ce, entry)
+	}
+	return nil
+}
+
+// nextEntryID returns the stream entry ID following id
+func nextEntryID(id string) string {
+	i := strings.IndexByte(id, '-')
+	if i < 0 {
+		return id
+	}
+
+	seq, err := strconv.ParseUint(id[i+1:], 10, 64)
+	if err != nil {
+		return id
+	}
+	return id[:i+1] + strconv.FormatUint(seq+1, 10)
+}
+
+// pendingEntry is an entry in the reply to the extended form of XPENDING
+type pendingEntry struct {
+	id   string
+	idle int64
+}
+
+func (p *pendingEntry) UnmarshalRESP(br *bufio.Reader) error {
+	var fields []string
+	if err := (resp2.Any{I: &fields}).UnmarshalRESP(br); err != nil {
+		return err
+	} else if len(fields) < 3 {
+		return errors.New("retryableredis: invalid xpending reply")
+	}
+
+	idle, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return err
+	}
+
+	p.id = fields[0]
+	p.idle = idle
+	return nil
+}
@@ -0,0 +1,131 @@
+package retryableredis
+
+import (
+	"context"
+	"sync"
+)
+
+// ReconnectCoordinator coordinates the reconnects of the conns sharing it, so
+// that when they all lose the server at once they don't all keep dialing it.
+// Once a dial to an address fails the conn that made it becomes its prober,
+// only it keeps dialing the address while the reconnects of the others wait
+// for one of its dials to succeed. If its reconnect gives up another conn
+// takes over.
+//
+// Conns are grouped by their Addr.
+type ReconnectCoordinator struct {
+	mu    sync.Mutex
+	addrs map[string]*addrHealth
+}
+
+type addrHealth struct {
+	down   bool
+	prober *retryableRedisConn
+
+	// changed is closed when the address comes back up or the prober gives
+	// up, and replaced by a new one
+	changed chan struct{}
+}
+
+// NewReconnectCoordinator returns a ReconnectCoordinator with every address
+// presumed reachable
+func NewReconnectCoordinator() *ReconnectCoordinator {
+	return &ReconnectCoordinator{addrs: make(map[string]*addrHealth)}
+}
+
+func (c *ReconnectCoordinator) health(addr string) *addrHealth {
+	h := c.addrs[addr]
+	if h == nil {
+		h = &addrHealth{changed: make(chan struct{})}
+		c.addrs[addr] = h
+	}
+	return h
+}
+
+// begin is called by rc before dialing addr, it returns nil if rc may dial it
+// or a channel to wait on before calling begin again. A nil coordinator always
+// lets rc dial.
+func (c *ReconnectCoordinator) begin(addr string, rc *retryableRedisConn) <-chan struct{} {
+	if c == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := c.health(addr)
+	if !h.down {
+		return nil
+	}
+	if h.prober == nil {
+		h.prober = rc
+	}
+	if h.prober == rc {
+		return nil
+	}
+	return h.changed
+}
+
+// end records the result of a dial of addr by rc
+func (c *ReconnectCoordinator) end(addr string, rc *retryableRedisConn, err error) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	h := c.health(addr)
+	switch {
+	case err != nil && !h.down:
+		h.down = true
+		h.prober = rc
+	case err == nil && h.down:
+		h.down = false
+		h.prober = nil
+		h.notify()
+	}
+}
+
+// release is called when the reconnect of rc gave up, another conn becomes
+// the prober if it was the one
+func (c *ReconnectCoordinator) release(addr string, rc *retryableRedisConn) {
+	if c == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if h := c.health(addr); h.prober == rc {
+		h.prober = nil
+		h.notify()
+	}
+}
+
+func (h *addrHealth) notify() {
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+// coordinatedReconnect is reconnect, but first waits while another conn
+// sharing the ReconnectCoordinator is probing the address
+func (rc *retryableRedisConn) coordinatedReconnect(ctx context.Context, info RetryInfo) error {
+	addr := rc.config().Addr
+	coord := rc.config().ReconnectCoordinator
+
+	for wait := coord.begin(addr, rc); wait != nil; wait = coord.begin(addr, rc) {
+		rc.setState(StateReconnecting, info.Err)
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-rc.closeCh:
+			return ErrClosed
+		}
+	}
+
+	err := rc.reconnect(info)
+	coord.end(addr, rc, err)
+	return err
+}
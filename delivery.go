@@ -0,0 +1,29 @@
+package retryableredis
+
+import "fmt"
+
+// DeliveryMode is whether a command is retried when it may have been executed
+// already, see DialConfig.DeliveryMode
+type DeliveryMode int
+
+const (
+	// AtLeastOnce retries commands after a network error even if they were
+	// sent, they may be executed twice
+	AtLeastOnce DeliveryMode = iota
+
+	// AtMostOnce doesn't retry a command after a network error once it was
+	// fully written, an AmbiguousResultError is returned instead. Commands
+	// that failed to be sent and error replies are still retried.
+	AtMostOnce
+)
+
+func (m DeliveryMode) String() string {
+	switch m {
+	case AtLeastOnce:
+		return "at-least-once"
+	case AtMostOnce:
+		return "at-most-once"
+	}
+
+	return fmt.Sprintf("DeliveryMode(%d)", int(m))
+}
@@ -0,0 +1,338 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ErrRetriesExhausted is matched by the errors returned once the retry or
+// reconnect budget for a Do call has been spent, check for it using
+// errors.Is(err, ErrRetriesExhausted)
+var ErrRetriesExhausted = errors.New("retryableredis: retries exhausted")
+
+// RetriesExhaustedError is returned when giving up after too many attempts, it
+// wraps the last underlying error
+type RetriesExhaustedError struct {
+	// Attempts is the number of attempts made before giving up
+	Attempts int
+
+	// Err is the error from the last attempt
+	Err error
+}
+
+func (e *RetriesExhaustedError) Error() string {
+	return fmt.Sprintf("retryableredis: giving up after %d attempts: %v", e.Attempts, e.Err)
+}
+
+func (e *RetriesExhaustedError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrRetriesExhausted) match
+func (e *RetriesExhaustedError) Is(target error) bool {
+	return target == ErrRetriesExhausted
+}
+
+// ErrStillLoading is matched by the error returned once MaxLoadingWait has
+// passed while the server keeps responding with LOADING errors, check for it
+// using errors.Is(err, ErrStillLoading)
+var ErrStillLoading = errors.New("retryableredis: server still loading")
+
+// StillLoadingError is returned when giving up waiting for the server to load
+// its dataset, it wraps the last LOADING error
+type StillLoadingError struct {
+	// Waited is how long was waited for the server
+	Waited time.Duration
+
+	Err error
+}
+
+func (e *StillLoadingError) Error() string {
+	return fmt.Sprintf("retryableredis: server still loading after %s: %v", e.Waited, e.Err)
+}
+
+func (e *StillLoadingError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrStillLoading) match
+func (e *StillLoadingError) Is(target error) bool {
+	return target == ErrStillLoading
+}
+
+// Attempt is a failed attempt made by a Do call
+type Attempt struct {
+	// Err is the error the attempt failed with
+	Err error
+
+	// Delay is how long was slept before the next attempt, it's 0 when the
+	// next attempt followed a reconnect
+	Delay time.Duration
+
+	// Time is when the attempt failed
+	Time time.Time
+}
+
+// AttemptsError is returned by Do when an action failed after being retried,
+// it wraps the final error and holds the history of the failed attempts.
+// Use errors.As(err, &attemptsErr) to get at it, errors.Is and errors.As
+// still match the final error.
+type AttemptsError struct {
+	// Attempts holds every failed attempt, oldest first
+	Attempts []Attempt
+
+	// Err is the error returned to the caller
+	Err error
+}
+
+func (e *AttemptsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AttemptsError) Unwrap() error {
+	return e.Err
+}
+
+// withAttempts wraps err in an AttemptsError, unless there's no history beyond
+// err itself. If join is set the errors of all attempts are joined.
+func withAttempts(history []Attempt, err error, join bool) error {
+	if len(history) == 0 || (len(history) == 1 && errors.Is(err, history[0].Err)) {
+		return err
+	}
+
+	attemptsErr := &AttemptsError{Attempts: history, Err: err}
+	if join {
+		return &joinedAttemptsError{attemptsErr}
+	}
+	return attemptsErr
+}
+
+// joinedAttemptsError is an AttemptsError whose message includes the errors of
+// all attempts, which errors.Is and errors.As match as well, see
+// DialConfig.JoinAttemptErrors
+type joinedAttemptsError struct {
+	*AttemptsError
+}
+
+func (e *joinedAttemptsError) Error() string {
+	var b strings.Builder
+	b.WriteString(e.Err.Error())
+	for i, attempt := range e.Attempts {
+		fmt.Fprintf(&b, "\nattempt %d: %v", i+1, attempt.Err)
+	}
+	return b.String()
+}
+
+func (e *joinedAttemptsError) Unwrap() error {
+	return e.AttemptsError
+}
+
+// Is and As match the final error first, so e.g ErrorCode gives its code
+func (e *joinedAttemptsError) Is(target error) bool {
+	if errors.Is(e.Err, target) {
+		return true
+	}
+	for _, attempt := range e.Attempts {
+		if errors.Is(attempt.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *joinedAttemptsError) As(target interface{}) bool {
+	if errors.As(e.Err, target) {
+		return true
+	}
+	for _, attempt := range e.Attempts {
+		if errors.As(attempt.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// errorCause returns the error that caused Do to fail, looking through
+// AttemptsError, RetriesExhaustedError and the like
+func errorCause(err error) error {
+	if joined, ok := err.(*joinedAttemptsError); ok {
+		err = joined.AttemptsError
+	}
+	if attemptsErr, ok := err.(*AttemptsError); ok {
+		err = attemptsErr.Err
+	}
+	if unwrapped := errors.Unwrap(err); unwrapped != nil {
+		return unwrapped
+	}
+	return err
+}
+
+// ErrClosed is returned by Do once the conn is closed
+var ErrClosed = errors.New("retryableredis: conn is closed")
+
+// ErrNotConnected is returned by Do in FailFast mode, and by Encode and Decode,
+// while the conn has no connection
+var ErrNotConnected = errors.New("retryableredis: not connected")
+
+// ErrTxnAborted is returned for the commands of a MULTI transaction that was
+// aborted because the connection broke, up to and including EXEC. If the
+// connection broke during EXEC the transaction may have been executed.
+var ErrTxnAborted = errors.New("retryableredis: transaction aborted by reconnect")
+
+// ErrReadOnlyMode is returned by Do for writes while the conn is in read-only
+// mode, see DialConfig.DegradeOnReadOnly
+var ErrReadOnlyMode = errors.New("retryableredis: writes disabled until reconnected to a master")
+
+// ErrCommandTimeout is returned by an attempt that got no response within
+// CommandTimeout
+var ErrCommandTimeout = errors.New("retryableredis: command timed out")
+
+// ErrorClass describes how an error is handled
+type ErrorClass int
+
+const (
+	// ErrorFatal errors are returned to the caller as-is
+	ErrorFatal ErrorClass = iota
+
+	// ErrorRetryable errors are retried on the same connection after a
+	// delay, e.g LOADING errors
+	ErrorRetryable
+
+	// ErrorReconnect errors mean the connection is broken, the action is
+	// retried after reconnecting
+	ErrorReconnect
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrorFatal:
+		return "fatal"
+	case ErrorRetryable:
+		return "retryable"
+	case ErrorReconnect:
+		return "reconnect"
+	}
+
+	return fmt.Sprintf("ErrorClass(%d)", int(c))
+}
+
+// classify returns the class of a non nil error returned by an attempt
+func (conf *DialConfig) classify(err error) ErrorClass {
+	// errors from this package, e.g from reconnecting before the attempt,
+	// are final
+	if errors.Is(err, ErrRetriesExhausted) || errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, ErrBudgetExhausted) || errors.Is(err, ErrClosed) ||
+		errors.Is(err, ErrNotConnected) || errors.Is(err, ErrTxnAborted) ||
+		errors.Is(err, ErrReplicationUnconfirmed) ||
+		errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrorFatal
+	}
+
+	if errors.Is(err, ErrWatchConflict) {
+		return ErrorRetryable
+	}
+
+	if conf.ClassifyError != nil {
+		return conf.ClassifyError(err)
+	}
+
+	if conf.ReconnectOnReadOnly && isReadOnlyErr(err) {
+		return ErrorReconnect
+	}
+
+	if conf.RetryBusy && ErrorCode(err) == "BUSY" {
+		return ErrorRetryable
+	}
+
+	if conf.RetryableErrorPrefixes != nil && errors.As(err, new(resp2.Error)) {
+		if conf.retryableCode(ErrorCode(err)) {
+			return ErrorRetryable
+		}
+		return ErrorFatal
+	}
+
+	return DefaultClassifyError(err)
+}
+
+// DefaultClassifyError is the built-in classification of errors: network
+// errors, the server closing the connection and ErrCommandTimeout cause a
+// reconnect, and LOADING, CLUSTERDOWN, TRYAGAIN and MASTERDOWN
+// error replies are retried. Custom classifiers can fall back to it.
+func DefaultClassifyError(err error) ErrorClass {
+	var netErr net.Error
+	if errors.As(err, &netErr) || errors.Is(err, ErrCommandTimeout) {
+		return ErrorReconnect
+	}
+
+	// the server closed the connection
+	if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return ErrorReconnect
+	}
+
+	if retryablePrefixes[ErrorCode(err)] {
+		return ErrorRetryable
+	}
+
+	return ErrorFatal
+}
+
+// DefaultRetryableErrorPrefixes are the codes of the error replies that are
+// retried by default, see DialConfig.RetryableErrorPrefixes
+var DefaultRetryableErrorPrefixes = []string{"LOADING", "CLUSTERDOWN", "TRYAGAIN", "MASTERDOWN"}
+
+// retryablePrefixes are the codes of the error replies retried by
+// DefaultClassifyError
+var retryablePrefixes = map[string]bool{
+	"LOADING":     true,
+	"CLUSTERDOWN": true,
+	"TRYAGAIN":    true,
+	"MASTERDOWN":  true,
+}
+
+// retryableCode reports whether error replies with the given code are retried
+func (conf *DialConfig) retryableCode(code string) bool {
+	return isRetryableCode(conf.RetryableErrorPrefixes, code)
+}
+
+// isRetryableCode reports whether code is one of prefixes, or one of the
+// default ones if prefixes is nil
+func isRetryableCode(prefixes []string, code string) bool {
+	if prefixes == nil {
+		return retryablePrefixes[code]
+	}
+	for _, prefix := range prefixes {
+		if prefix == code {
+			return true
+		}
+	}
+	return false
+}
+
+// ErrorCode returns the code of an error reply (e.g "LOADING"), which is the
+// first word of it, or "" if err isn't one. It also works for error replies
+// wrapped in other errors.
+func ErrorCode(err error) string {
+	var respErr resp2.Error
+	if !errors.As(err, &respErr) {
+		return ""
+	}
+
+	msg := respErr.Error()
+	if i := strings.IndexByte(msg, ' '); i >= 0 {
+		return msg[:i]
+	}
+	return msg
+}
+
+// isReadOnlyErr reports whether err is a READONLY error reply, sent when
+// writing to a replica
+func isReadOnlyErr(err error) bool {
+	return ErrorCode(err) == "READONLY"
+}
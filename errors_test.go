@@ -0,0 +1,42 @@
+package retryableredis_test
+
+import (
+	"testing"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestReconnectOnReadOnly(t *testing.T) {
+	s := newFlakyServer(t)
+
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{ReconnectOnReadOnly: true})
+
+	// the address pointed at a demoted master, it's a master again after
+	// reconnecting
+	s.Proxy.Respond(retryableredistest.Error("READONLY You can't write against a read only replica."), 1)
+	if err := conn.Do(retryableredis.Cmd(nil, "SET", "k", "v")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Redis.Get("k"); v != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+	cb.check(t)
+}
+
+func TestReadOnlyNotRetriedByDefault(t *testing.T) {
+	s := newFlakyServer(t)
+
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{})
+
+	s.Proxy.Respond(retryableredistest.Error("READONLY You can't write against a read only replica."), 1)
+	err := conn.Do(retryableredis.Cmd(nil, "SET", "k", "v"))
+	if code := retryableredis.ErrorCode(err); code != "READONLY" {
+		t.Fatalf("got %v, want a READONLY error", err)
+	}
+	if s.Redis.Exists("k") {
+		t.Fatal("SET was executed")
+	}
+}
@@ -0,0 +1,274 @@
+package retryableredis
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// RetryInfo describes a retry or reconnect, it's passed to the OnRetryInfo and
+// OnReconnectInfo callbacks
+type RetryInfo struct {
+	// Context is the context of the Do call, as returned by OnDoStart
+	Context context.Context
+
+	// Cmds are the names of the commands in the action being retried, and
+	// Keys its keys. Both are empty for reconnects not caused by an action.
+	Cmds []string
+	Keys []string
+
+	// Attempt is the number of the retry or reconnect attempt, starting at 1
+	Attempt int
+
+	// Delay is the time waited before this attempt
+	Delay time.Duration
+
+	// Class is how Err was classified, and Code its code if it's an error
+	// reply (see ErrorCode)
+	Class ErrorClass
+	Code  string
+
+	// Err is the error that caused the retry or reconnect, nil for the
+	// initial connect
+	Err error
+}
+
+// newRetryInfo returns a RetryInfo for a attempt of a that failed with err, a
+// may be nil
+func (conf *DialConfig) newRetryInfo(ctx context.Context, a radix.Action, err error) RetryInfo {
+	info := RetryInfo{
+		Context: ctx,
+		Err:     err,
+	}
+	if err != nil {
+		info.Class = conf.classify(err)
+		info.Code = ErrorCode(err)
+	}
+	if a != nil {
+		info.Cmds = actionCmdNames(a)
+		info.Keys = a.Keys()
+	}
+
+	return info
+}
+
+func (conf *DialConfig) onRetry(info RetryInfo) {
+	if conf.RetrySampler.allow(conf.onRetriesSuppressed) {
+		conf.logRetry(info)
+		if conf.OnRetry != nil && info.Class == ErrorRetryable {
+			conf.OnRetry(info.Err)
+		}
+	}
+	if conf.OnRetryInfo != nil {
+		conf.OnRetryInfo(info)
+	}
+}
+
+func (conf *DialConfig) onReconnect(info RetryInfo) {
+	// the initial connect isn't worth logging
+	if info.Err != nil {
+		conf.logReconnect(info)
+	}
+	if conf.OnReconnect != nil {
+		conf.OnReconnect(info.Err)
+	}
+	if conf.OnReconnectInfo != nil {
+		conf.OnReconnectInfo(info)
+	}
+}
+
+// onGiveUp calls OnGiveUp for every command of an action that was given up
+// on, args returns the commands with their arguments, see giveUpArgs
+func (conf *DialConfig) onGiveUp(cmds []string, argsFn func() [][]string, err error) {
+	if conf.OnGiveUp == nil {
+		return
+	}
+
+	args := argsFn()
+	if len(args) < 1 {
+		for _, cmd := range cmds {
+			conf.OnGiveUp(cmd, nil, err)
+		}
+		return
+	}
+	for _, cmdArgs := range args {
+		conf.OnGiveUp(cmdArgs[0], cmdArgs[1:], err)
+	}
+}
+
+// giveUpArgs returns a func returning the commands of a with their arguments
+// for OnGiveUp. Actions made with Cmd and FlatCmd are only marshaled once
+// they were given up on, a FlatCmd with args that are read from, like a
+// resp.LenReader, keeps what was written for them instead. Foreign actions
+// have no args.
+func giveUpArgs(a radix.Action) func() [][]string {
+	switch v := a.(type) {
+	case *RetryableCmd:
+		return func() [][]string { return actionArgs(v) }
+	case *RetryableFlatCmd:
+		if readsArgs(v.args) {
+			v.wire = new(bytes.Buffer)
+		}
+		return func() [][]string { return actionArgs(v) }
+	case *capturedCmd, *retryableAction:
+		return func() [][]string { return actionArgs(v) }
+	case wrappedCmd:
+		return giveUpArgs(v.unwrapCmd())
+	case *retryAction:
+		return giveUpArgs(v.Action)
+	case *pipeline:
+		fns := make([]func() [][]string, len(v.cmds))
+		for i, cmd := range v.cmds {
+			fns[i] = giveUpArgs(cmd)
+		}
+		return func() [][]string {
+			var res [][]string
+			for _, fn := range fns {
+				res = append(res, fn()...)
+			}
+			return res
+		}
+	}
+
+	// other actions can't be inspected
+	return func() [][]string { return nil }
+}
+
+// DoInfo describes a completed Do call, it's passed to the OnDo callback
+type DoInfo struct {
+	// Context is the context of the Do call, as returned by OnDoStart
+	Context context.Context
+
+	Cmds []string
+	Keys []string
+
+	// Duration is the total time spent, including retries and reconnects
+	Duration time.Duration
+
+	// Attempts is the number of times the action was attempted
+	Attempts int
+
+	// Err is the returned error, Class how the last attempt's error was
+	// classified and Code its code if it's an error reply (see ErrorCode)
+	Err   error
+	Class ErrorClass
+	Code  string
+}
+
+// DialInfo describes a completed dial, it's passed to the OnDial callback
+type DialInfo struct {
+	Duration time.Duration
+	Err      error
+}
+
+// DefaultEventBufferSize is the default DialConfig.EventBufferSize
+const DefaultEventBufferSize = 64
+
+// EventType is the type of an Event
+type EventType int
+
+const (
+	// EventRetried is sent when an action is retried
+	EventRetried EventType = iota + 1
+	// EventReconnecting is sent for every reconnect attempt
+	EventReconnecting
+	// EventReconnected is sent when a reconnect succeeded
+	EventReconnected
+	// EventGaveUp is sent when retrying an action was given up on
+	EventGaveUp
+	// EventClosed is sent when the conn is closed, it's the last event
+	EventClosed
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventRetried:
+		return "retried"
+	case EventReconnecting:
+		return "reconnecting"
+	case EventReconnected:
+		return "reconnected"
+	case EventGaveUp:
+		return "gave_up"
+	case EventClosed:
+		return "closed"
+	}
+
+	return fmt.Sprintf("EventType(%d)", int(t))
+}
+
+// Event is a lifecycle event of a conn, see Conn.Events
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	// Cmds are the names of the commands in the action the event is about,
+	// if any
+	Cmds []string
+
+	// Attempt and Delay are set like in RetryInfo for EventRetried and
+	// EventReconnecting
+	Attempt int
+	Delay   time.Duration
+
+	// Err is the cause of the event, if any
+	Err error
+}
+
+// Events returns a channel receiving the lifecycle events of the conn from
+// now on. Events are dropped while the channel is full, so a slow consumer
+// never blocks the conn. It's closed after EventClosed.
+func (rc *retryableRedisConn) Events() <-chan Event {
+	rc.eventsMu.Lock()
+	defer rc.eventsMu.Unlock()
+
+	if rc.events == nil {
+		size := rc.config().EventBufferSize
+		if size < 1 {
+			size = DefaultEventBufferSize
+		}
+		rc.events = make(chan Event, size)
+		if rc.eventsClosed {
+			close(rc.events)
+		}
+	}
+	return rc.events
+}
+
+// emit sends an event to the Events channel, if it's being used and not full
+func (rc *retryableRedisConn) emit(t EventType, info RetryInfo) {
+	rc.eventsMu.Lock()
+	defer rc.eventsMu.Unlock()
+
+	if rc.events == nil || rc.eventsClosed {
+		return
+	}
+
+	select {
+	case rc.events <- Event{
+		Type:    t,
+		Time:    time.Now(),
+		Cmds:    info.Cmds,
+		Attempt: info.Attempt,
+		Delay:   info.Delay,
+		Err:     info.Err,
+	}:
+	default:
+	}
+}
+
+// closeEvents sends EventClosed and closes the Events channel
+func (rc *retryableRedisConn) closeEvents() {
+	rc.emit(EventClosed, RetryInfo{})
+
+	rc.eventsMu.Lock()
+	defer rc.eventsMu.Unlock()
+
+	if rc.events != nil {
+		close(rc.events)
+	}
+	rc.eventsClosed = true
+}
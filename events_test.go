@@ -0,0 +1,93 @@
+package retryableredis_test
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+func TestGiveUpReaderArgs(t *testing.T) {
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		if args[0] == "SET" {
+			return retryableredistest.Drop()
+		}
+		return retryableredistest.OK()
+	})
+	defer srv.Close()
+
+	var gaveUp [][]string
+	var cb callbacks
+	conn := dialProxy(t, srv.Addr(), &cb, retryableredis.DialConfig{
+		MaxRetries: 1,
+		OnGiveUp: func(cmd string, args []string, err error) {
+			gaveUp = append(gaveUp, append([]string{cmd}, args...))
+		},
+	})
+
+	// OnGiveUp gets the value the reader had, which is only read when SET is
+	// first written
+	value := resp.NewLenReader(strings.NewReader("v"), 1)
+	if err := conn.Do(retryableredis.FlatCmd(nil, "SET", "k", value)); err == nil {
+		t.Fatal("SET succeeded")
+	}
+
+	want := [][]string{{"SET", "k", "v"}}
+	var sent [][]string
+	for _, cmd := range srv.Commands() {
+		if cmd[0] == "SET" {
+			sent = append(sent, cmd)
+		}
+	}
+	if len(sent) < 1 || !reflect.DeepEqual(sent[:1], want) {
+		t.Fatalf("sent %q, want %q first", sent, want)
+	}
+	if !reflect.DeepEqual(gaveUp, want[:1]) {
+		t.Fatalf("OnGiveUp got %q, want %q", gaveUp, want)
+	}
+}
+
+func TestGiveUpForeignArgs(t *testing.T) {
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		if args[0] == "SET" {
+			return retryableredistest.Drop()
+		}
+		return retryableredistest.OK()
+	})
+	defer srv.Close()
+
+	gaveUp := 0
+	var cb callbacks
+	conn := dialProxy(t, srv.Addr(), &cb, retryableredis.DialConfig{
+		MaxRetries: 1,
+		OnGiveUp: func(cmd string, args []string, err error) {
+			gaveUp++
+		},
+	})
+
+	// a foreign action isn't marshaled for OnGiveUp, so its reader is only
+	// read when it's sent
+	value := resp.NewLenReader(strings.NewReader("v"), 1)
+	if err := conn.Do(foreignCmd{radix.FlatCmd(nil, "SET", "k", value)}); err == nil {
+		t.Fatal("SET succeeded")
+	}
+
+	want := []string{"SET", "k", "v"}
+	var sent []string
+	for _, cmd := range srv.Commands() {
+		if cmd[0] == "SET" {
+			sent = cmd
+			break
+		}
+	}
+	if !reflect.DeepEqual(sent, want) {
+		t.Fatalf("sent %q, want %q", sent, want)
+	}
+	if gaveUp != 0 {
+		t.Fatalf("OnGiveUp called %d times, want 0", gaveUp)
+	}
+}
@@ -1,7 +1,27 @@
 module github.com/jonas747/retryableredis
 
-go 1.13
+go 1.18
 
-require golang.org/x/xerrors v0.0.0-20190528162220-0421b64034aa
+require (
+	github.com/alicebob/miniredis/v2 v2.39.0
+	github.com/mediocregopher/radix/v3 v3.3.2
+	github.com/mediocregopher/radix/v4 v4.1.4
+	github.com/prometheus/client_golang v1.11.1
+	go.opentelemetry.io/otel v1.0.0
+	go.opentelemetry.io/otel/trace v1.0.0
+)
 
-require github.com/mediocregopher/radix/v3 v3.3.2
+require (
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cespare/xxhash/v2 v2.1.1 // indirect
+	github.com/golang/protobuf v1.4.3 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.26.0 // indirect
+	github.com/prometheus/procfs v0.6.0 // indirect
+	github.com/tilinna/clock v1.0.2 // indirect
+	github.com/yuin/gopher-lua v1.1.1 // indirect
+	golang.org/x/sys v0.0.0-20210603081109-ebe580a85c40 // indirect
+	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
+	google.golang.org/protobuf v1.26.0-rc.1 // indirect
+)
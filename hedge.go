@@ -0,0 +1,111 @@
+package retryableredis
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// hedgeable returns the args and receiver of a read-only cmd made with Cmd or
+// FlatCmd, which can be performed twice at once, see PoolConfig.HedgeAfter
+func hedgeable(a radix.Action) ([]string, interface{}, bool) {
+	var rcv interface{}
+	switch v := a.(type) {
+	case *RetryableCmd:
+		rcv = v.rcv
+	case *RetryableFlatCmd:
+		rcv = v.rcv
+	default:
+		return nil, nil, false
+	}
+
+	name := strings.ToUpper(actionCmdNames(a)[0])
+	if !IsReadOnlyCommand(name) {
+		return nil, nil, false
+	}
+	if _, ok := blockingCommands[name]; ok {
+		return nil, nil, false
+	}
+
+	cmds := actionArgs(a)
+	if len(cmds) != 1 {
+		return nil, nil, false
+	}
+	return cmds[0], rcv, true
+}
+
+type hedgedResult struct {
+	reply *coalescedReply
+	err   error
+}
+
+// doHedged performs the cmd args on a conn from the pool, and again on a
+// second one if there's no reply after HedgeAfter. The first successful reply
+// is unmarshaled into rcv and the other attempt is cancelled.
+func (p *Pool) doHedged(ctx context.Context, args []string, rcv interface{}) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	// the conns of the attempts in progress
+	var mu sync.Mutex
+	running := make(map[*poolConn]bool)
+	defer func() {
+		cancel()
+		// the attempt still waiting for its reply is interrupted, instead of
+		// holding on to its conn until the reply arrives
+		mu.Lock()
+		defer mu.Unlock()
+		for pc := range running {
+			pc.abortInFlight()
+		}
+	}()
+
+	results := make(chan hedgedResult, 2)
+	start := func() {
+		go func() {
+			pc, err := p.get(ctx)
+			if err != nil {
+				results <- hedgedResult{err: err}
+				return
+			}
+			mu.Lock()
+			running[pc] = true
+			mu.Unlock()
+
+			reply := new(coalescedReply)
+			err = pc.DoContext(ctx, Cmd(reply, args[0], args[1:]...))
+			mu.Lock()
+			delete(running, pc)
+			mu.Unlock()
+			p.putAfter(pc, err)
+			results <- hedgedResult{reply: reply, err: err}
+		}()
+	}
+
+	start()
+	pending := 1
+	timer := time.NewTimer(p.conf.HedgeAfter)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-timer.C:
+			start()
+			pending++
+		case res := <-results:
+			pending--
+			// a failed attempt is only returned if there's no other one left
+			// to wait for
+			if res.err != nil && pending > 0 {
+				continue
+			}
+			if res.err != nil || rcv == nil {
+				return res.err
+			}
+			return res.reply.raw.UnmarshalInto(resp2.Any{I: rcv})
+		}
+	}
+}
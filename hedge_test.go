@@ -0,0 +1,60 @@
+package retryableredis_test
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestHedgeCancelsLoser(t *testing.T) {
+	var gets int32
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		if args[0] == "GET" && atomic.AddInt32(&gets, 1) == 1 {
+			return retryableredistest.Value("slow").After(time.Second)
+		}
+		return retryableredistest.Value("fast")
+	})
+	defer srv.Close()
+
+	pool, err := retryableredis.NewPool(&retryableredis.PoolConfig{
+		Network:    "tcp",
+		Addr:       srv.Addr(),
+		Size:       2,
+		HedgeAfter: 10 * time.Millisecond,
+		ConnConfig: retryableredis.DialConfig{
+			RetryBackoff:     testBackoff,
+			ReconnectBackoff: testBackoff,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	var v string
+	if err := pool.Do(retryableredis.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "fast" {
+		t.Fatalf("got %q, want %q", v, "fast")
+	}
+
+	// the slow attempt gives its conn back instead of waiting for the reply
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for pool.Stats().InUse > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the slow attempt is still using its conn")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// and it's usable afterwards
+	for i := 0; i < 2; i++ {
+		if err := pool.Do(retryableredis.Cmd(nil, "SET", "k", "v")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
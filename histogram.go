@@ -0,0 +1,128 @@
+package retryableredis
+
+import (
+	"math/bits"
+	"strings"
+	"time"
+)
+
+const (
+	// histogramSubBits is the number of bits of precision kept per power of
+	// two, 3 keeps the quantiles within 12.5% of the real value
+	histogramSubBits = 3
+	histogramSub     = 1 << histogramSubBits
+
+	// histogramBuckets covers latencies up to 2^32µs (about 71 minutes),
+	// longer ones are counted in the last bucket
+	histogramBuckets = (32 - histogramSubBits + 1) * histogramSub
+)
+
+// LatencyHistogram is a histogram of latencies with exponentially growing
+// buckets, covering microseconds to an hour in a fixed amount of memory
+type LatencyHistogram struct {
+	// Count is the number of latencies recorded, Sum their total and Max
+	// the highest one
+	Count uint64
+	Sum   time.Duration
+	Max   time.Duration
+
+	buckets []uint64
+}
+
+// histogramBucket returns the bucket of a latency of us microseconds
+func histogramBucket(us uint64) int {
+	if us < histogramSub {
+		return int(us)
+	}
+
+	exp := bits.Len64(us) - 1
+	sub := int(us>>uint(exp-histogramSubBits)) & (histogramSub - 1)
+	idx := (exp-histogramSubBits+1)*histogramSub + sub
+	if idx >= histogramBuckets {
+		return histogramBuckets - 1
+	}
+	return idx
+}
+
+// histogramBucketMax returns the highest latency in microseconds counted in
+// bucket idx
+func histogramBucketMax(idx int) uint64 {
+	if idx < histogramSub {
+		return uint64(idx)
+	}
+
+	exp := idx/histogramSub + histogramSubBits - 1
+	sub := uint64(idx % histogramSub)
+	width := uint64(1) << uint(exp-histogramSubBits)
+	return (histogramSub+sub)*width + width - 1
+}
+
+func (h *LatencyHistogram) record(d time.Duration) {
+	if h.buckets == nil {
+		h.buckets = make([]uint64, histogramBuckets)
+	}
+	if d < 0 {
+		d = 0
+	}
+
+	h.buckets[histogramBucket(uint64(d/time.Microsecond))]++
+	h.Count++
+	h.Sum += d
+	if d > h.Max {
+		h.Max = d
+	}
+}
+
+// clone returns a copy of h that doesn't share its buckets
+func (h *LatencyHistogram) clone() LatencyHistogram {
+	c := *h
+	c.buckets = append([]uint64(nil), h.buckets...)
+	return c
+}
+
+// Mean returns the average latency
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Quantile returns the latency below which the fraction q of the latencies
+// fall, e.g 0.99 for the 99th percentile
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+
+	rank := uint64(q*float64(h.Count) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+
+	var seen uint64
+	for idx, n := range h.buckets {
+		seen += n
+		if seen < rank {
+			continue
+		}
+
+		d := time.Duration(histogramBucketMax(idx)) * time.Microsecond
+		if d > h.Max {
+			d = h.Max
+		}
+		return d
+	}
+	return h.Max
+}
+
+// latencyKey returns the key of the histograms for an action performing cmds
+func latencyKey(cmds []string) string {
+	switch len(cmds) {
+	case 0:
+		return "UNKNOWN"
+	case 1:
+		return strings.ToUpper(cmds[0])
+	}
+	return "PIPELINE"
+}
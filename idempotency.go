@@ -0,0 +1,81 @@
+package retryableredis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// idempotencyScript runs the cmd in ARGV[2:] unless the request id in KEYS[1]
+// was seen already, in which case the stored reply is returned. The reply is
+// stored for ARGV[1] milliseconds.
+const idempotencyScript = `
+local done = redis.call('GET', KEYS[1])
+if done then
+	return cjson.decode(done)[1]
+end
+local res = redis.call(unpack(ARGV, 2))
+redis.call('SET', KEYS[1], cjson.encode({res}), 'PX', ARGV[1])
+return res
+`
+
+// IdempotencyKeyPrefix is the prefix of the keys IdempotentCmd stores its
+// request ids in
+const IdempotencyKeyPrefix = "retryableredis:req:"
+
+// IdempotentCmd is like Cmd, but the cmd is run in a lua script along with a
+// request id generated here. Its reply is stored under the id for ttl, so when
+// the cmd is retried after a network error it isn't run again and the stored
+// reply is returned instead. It's retried even with RetryOnlyIdempotent set or
+// in AtMostOnce mode.
+//
+// Like with Cmd the first arg is the key, the request id is stored in the same
+// hash slot. The cmd has to be allowed in scripts, and blocking cmds don't
+// block.
+func IdempotentCmd(rcv interface{}, ttl time.Duration, cmd string, args ...string) radix.CmdAction {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+
+	keys := []string{IdempotencyKeyPrefix + hex.EncodeToString(id)}
+	if len(args) > 0 {
+		keys = []string{IdempotencyKeyPrefix + "{" + hashTag(args[0]) + "}:" + hex.EncodeToString(id), args[0]}
+	}
+
+	ms := ttl.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+
+	evalArgs := make([]string, 0, 5+len(args))
+	evalArgs = append(evalArgs, idempotencyScript, strconv.Itoa(len(keys)))
+	evalArgs = append(evalArgs, keys...)
+	evalArgs = append(evalArgs, strconv.FormatInt(ms, 10), cmd)
+	evalArgs = append(evalArgs, args...)
+	return Cmd(rcv, "EVAL", evalArgs...)
+}
+
+// isIdempotencyCmd reports whether a was made with IdempotentCmd
+func isIdempotencyCmd(a radix.Action) bool {
+	if wc, ok := a.(wrappedCmd); ok {
+		a = wc.unwrapCmd()
+	}
+	cmd, ok := a.(*RetryableCmd)
+	return ok && cmd.cmd == "EVAL" && len(cmd.args) > 0 && cmd.args[0] == idempotencyScript
+}
+
+// hashTag returns the part of key its hash slot is computed from, keys with
+// an empty hash tag like "a{}b" aren't handled
+func hashTag(key string) string {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			return key[start+1 : start+1+end]
+		}
+	}
+	return key
+}
@@ -0,0 +1,46 @@
+package retryableredis_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestIdempotentCmdRetried(t *testing.T) {
+	s := newFlakyServer(t)
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{RetryOnlyIdempotent: true})
+
+	// the INCR runs, but its reply is lost
+	s.Proxy.BreakOn("EVAL", retryableredistest.FaultDrop)
+	var n int
+	if err := conn.Do(retryableredis.IdempotentCmd(&n, time.Minute, "INCR", "k")); err != nil {
+		t.Fatal(err)
+	}
+	cb.check(t)
+
+	// the retry got the stored reply instead of running it again
+	if n != 1 {
+		t.Fatalf("INCR returned %d, want 1", n)
+	}
+	if v, _ := s.Redis.Get("k"); v != "1" {
+		t.Fatalf("k is %q, want %q", v, "1")
+	}
+}
+
+func TestIdempotentCmdOnlyIdempotent(t *testing.T) {
+	s := newFlakyServer(t)
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{RetryOnlyIdempotent: true})
+
+	// a plain INCR isn't retried, it may have run
+	s.Proxy.BreakOn("INCR", retryableredistest.FaultDrop)
+	err := conn.Do(retryableredis.Cmd(nil, "INCR", "k"))
+	var ambiguous *retryableredis.AmbiguousResultError
+	if !errors.As(err, &ambiguous) {
+		t.Fatalf("got %v, want an AmbiguousResultError", err)
+	}
+}
@@ -0,0 +1,19 @@
+package retryableredis
+
+import (
+	"context"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// DoFunc performs an action like Conn.DoContext, it's what interceptors wrap,
+// see DialConfig.Interceptors
+type DoFunc func(ctx context.Context, a radix.Action) error
+
+// intercept wraps fn in interceptors, the first one being the outermost
+func intercept(interceptors []func(next DoFunc) DoFunc, fn DoFunc) DoFunc {
+	for i := len(interceptors) - 1; i >= 0; i-- {
+		fn = interceptors[i](fn)
+	}
+	return fn
+}
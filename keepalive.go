@@ -0,0 +1,154 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// DefaultFailbackInterval is how often a conn connected to a fallback address
+// tries the preferred ones again
+const DefaultFailbackInterval = 30 * time.Second
+
+// maintain runs the keepalive, lifetime and failback checks in the background until the
+// conn is closed
+func (rc *retryableRedisConn) maintain() {
+	t := time.NewTicker(rc.config().maintainInterval())
+	defer t.Stop()
+
+	for {
+		select {
+		case <-rc.closeCh:
+			return
+		case <-t.C:
+			rc.checkIdle()
+		}
+	}
+}
+
+// maintainInterval returns how often the idle checks are run
+func (conf *DialConfig) maintainInterval() time.Duration {
+	interval := conf.KeepAliveInterval
+	if len(conf.Addrs) > 1 && (interval <= 0 || interval > conf.failbackInterval()) {
+		interval = conf.failbackInterval()
+	}
+	if lifetimeCheck := conf.MaxConnLifetime / 10; conf.MaxConnLifetime > 0 &&
+		(interval <= 0 || interval > lifetimeCheck) {
+		interval = lifetimeCheck
+	}
+
+	if interval < time.Millisecond {
+		interval = time.Millisecond
+	}
+	return interval
+}
+
+// connLifetime returns the lifetime of a new connection
+func (conf *DialConfig) connLifetime() time.Duration {
+	return conf.MaxConnLifetime - time.Duration(rand.Int63n(int64(conf.MaxConnLifetime)/10+1))
+}
+
+// checkIdle redials expired connections, fails back to preferred addresses and
+// pings the server if the conn is not in use, reconnecting if the connection
+// is dead
+func (rc *retryableRedisConn) checkIdle() {
+	// the conn is evidently alive if it's in use
+	select {
+	case rc.lock <- struct{}{}:
+	default:
+		return
+	}
+	defer rc.unlock()
+
+	if rc.State() == StateClosed {
+		return
+	}
+
+	rc.redialIfExpired()
+	rc.failback()
+	if rc.config().KeepAliveInterval <= 0 {
+		return
+	}
+	// a PING would be queued in the transaction, or get no reply
+	if rc.session.multi || rc.session.repliesOff() {
+		return
+	}
+
+	var err error
+	if rc.inner != nil {
+		timeout := rc.config().CommandTimeout
+		if timeout <= 0 {
+			timeout = rc.config().KeepAliveInterval
+		}
+		if err = rc.doInnerTimeout(radix.Cmd(nil, "PING"), timeout); err == nil {
+			return
+		}
+
+		// error replies mean the server is there
+		if errors.As(err, new(resp2.Error)) {
+			return
+		}
+	}
+
+	// stopped by Close
+	ctx := context.Background()
+	rc.reconnectLoop(ctx, rc.config().newRetryInfo(ctx, nil, err))
+}
+
+// redialIfExpired replaces inner with a new connection once it reached
+// MaxConnLifetime. The old connection is kept if dialing fails. rc.lock has to
+// be held.
+func (rc *retryableRedisConn) redialIfExpired() {
+	if rc.config().MaxConnLifetime <= 0 || rc.inner == nil || time.Now().Before(rc.expiresAt) {
+		return
+	}
+	// switching connections would break the transaction
+	if rc.session.multi {
+		return
+	}
+
+	inner, addrIdx, err := rc.dialSession(-1)
+	if err != nil {
+		// try again on the next check
+		rc.expiresAt = time.Now().Add(rc.config().maintainInterval())
+		return
+	}
+
+	rc.inner.Close()
+	rc.stats.dialed(false, nil)
+	rc.setInner(inner, addrIdx)
+}
+
+// failback switches to a more preferred address in Addrs if one is reachable
+// again, rc.lock has to be held
+func (rc *retryableRedisConn) failback() {
+	if rc.addrIdx == 0 || rc.inner == nil || len(rc.config().SentinelAddrs) > 0 || rc.session.multi {
+		return
+	}
+
+	now := time.Now()
+	if now.Before(rc.failbackAt) {
+		return
+	}
+	rc.failbackAt = now.Add(rc.config().failbackInterval())
+
+	inner, addrIdx, err := rc.dialSession(rc.addrIdx)
+	if err != nil {
+		return
+	}
+
+	rc.inner.Close()
+	rc.stats.dialed(false, nil)
+	rc.setInner(inner, addrIdx)
+}
+
+func (conf *DialConfig) failbackInterval() time.Duration {
+	if conf.FailbackInterval > 0 {
+		return conf.FailbackInterval
+	}
+	return DefaultFailbackInterval
+}
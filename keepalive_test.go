@@ -0,0 +1,35 @@
+package retryableredis_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestKeepAliveSkipsTransactions(t *testing.T) {
+	srv := retryableredistest.NewServer(nil)
+	defer srv.Close()
+
+	conn, err := retryableredis.Dial(&retryableredis.DialConfig{
+		Network:           "tcp",
+		Addr:              srv.Addr(),
+		Dialer:            srv.Dial,
+		KeepAliveInterval: time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if err := conn.Do(retryableredis.Cmd(nil, "MULTI")); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+
+	cmds := srv.Commands()
+	if last := cmds[len(cmds)-1]; last[0] != "MULTI" {
+		t.Fatalf("%v was sent inside the transaction", last)
+	}
+}
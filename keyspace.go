@@ -0,0 +1,238 @@
+package retryableredis
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// KeyspaceEvent is a keyspace notification, see
+// https://redis.io/topics/notifications
+type KeyspaceEvent struct {
+	// DB is the database of the key
+	DB int
+
+	// Key is the key the event happened to, Event the event (e.g "set" or
+	// "expired")
+	Key   string
+	Event string
+
+	// Gap is set for the marker sent after reconnecting, which means events
+	// that happened while reconnecting were lost. All the other fields are
+	// empty for it.
+	Gap bool
+}
+
+// KeyspaceOpts are the options for NewKeyspaceSubscriber
+type KeyspaceOpts struct {
+	// DB is the database to receive events for, -1 for all of them
+	DB int
+
+	// Keys are the patterns of the keys to receive events for, using the
+	// __keyspace@<db>__ channels. Events are the events to receive, using the
+	// __keyevent@<db>__ channels. If neither is set it defaults to all keys.
+	Keys   []string
+	Events []string
+
+	// NotifyConfig, if set, is set as notify-keyspace-events on every conn
+	// (e.g "KEA"), so that notifications are enabled again after the server
+	// restarted
+	NotifyConfig string
+
+	// BufferSize is the size of the chan returned by Events
+	BufferSize int
+}
+
+// KeyspaceSubscriber receives keyspace notifications using a PubSub, so it
+// re-subscribes after reconnecting. A KeyspaceEvent with Gap set is sent after
+// every reconnect.
+type KeyspaceSubscriber struct {
+	ps     *PubSub
+	msgCh  chan radix.PubSubMessage
+	events chan KeyspaceEvent
+
+	// gapCh is signaled when the conn reconnected, dialed is set once the
+	// initial conn is dialed
+	gapCh  chan struct{}
+	dialed bool
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+	doneCh    chan struct{}
+}
+
+// NewKeyspaceSubscriber dials a PubSub with conf and subscribes to the
+// notifications in opts
+func NewKeyspaceSubscriber(conf *DialConfig, opts KeyspaceOpts) (*KeyspaceSubscriber, error) {
+	ks := &KeyspaceSubscriber{
+		msgCh:   make(chan radix.PubSubMessage, opts.BufferSize),
+		events:  make(chan KeyspaceEvent, opts.BufferSize),
+		gapCh:   make(chan struct{}, 1),
+		closeCh: make(chan struct{}),
+		doneCh:  make(chan struct{}),
+	}
+
+	ps, err := newPubSub(conf, func(conn radix.Conn) error {
+		return ks.setup(conn, opts.NotifyConfig)
+	})
+	if err != nil {
+		return nil, err
+	}
+	ks.ps = ps
+
+	if err := ps.PSubscribe(ks.msgCh, keyspacePatterns(opts)...); err != nil {
+		ps.Close()
+		return nil, err
+	}
+
+	go ks.forward()
+	return ks, nil
+}
+
+// setup enables notifications on a new conn, and signals the gap if it's
+// replacing a previous one. It's only called with the PubSub lock held.
+func (ks *KeyspaceSubscriber) setup(conn radix.Conn, notifyConfig string) error {
+	if notifyConfig != "" {
+		if err := conn.Do(radix.Cmd(nil, "CONFIG", "SET", "notify-keyspace-events", notifyConfig)); err != nil {
+			return err
+		}
+	}
+
+	if ks.dialed {
+		select {
+		case ks.gapCh <- struct{}{}:
+		default:
+		}
+	}
+	ks.dialed = true
+	return nil
+}
+
+// keyspacePatterns returns the channel patterns to subscribe to for opts
+func keyspacePatterns(opts KeyspaceOpts) []string {
+	db := "*"
+	if opts.DB >= 0 {
+		db = strconv.Itoa(opts.DB)
+	}
+
+	keys := opts.Keys
+	if len(keys) == 0 && len(opts.Events) == 0 {
+		keys = []string{"*"}
+	}
+
+	var patterns []string
+	for _, key := range keys {
+		patterns = append(patterns, "__keyspace@"+db+"__:"+key)
+	}
+	for _, event := range opts.Events {
+		patterns = append(patterns, "__keyevent@"+db+"__:"+event)
+	}
+	return patterns
+}
+
+func (ks *KeyspaceSubscriber) forward() {
+	defer close(ks.doneCh)
+	defer close(ks.events)
+
+	for {
+		// the gap has to go before any messages received after it
+		select {
+		case <-ks.gapCh:
+			if !ks.send(KeyspaceEvent{Gap: true}) {
+				return
+			}
+			continue
+		default:
+		}
+
+		select {
+		case <-ks.closeCh:
+			return
+		case <-ks.gapCh:
+			if !ks.send(KeyspaceEvent{Gap: true}) {
+				return
+			}
+		case msg := <-ks.msgCh:
+			if ev, ok := parseKeyspaceMsg(msg); ok && !ks.send(ev) {
+				return
+			}
+		}
+	}
+}
+
+// send sends ev on events, reporting false if ks was closed first
+func (ks *KeyspaceSubscriber) send(ev KeyspaceEvent) bool {
+	select {
+	case ks.events <- ev:
+		return true
+	case <-ks.closeCh:
+		return false
+	}
+}
+
+// parseKeyspaceMsg parses a message from a __keyspace or __keyevent channel
+func parseKeyspaceMsg(msg radix.PubSubMessage) (KeyspaceEvent, bool) {
+	var ev KeyspaceEvent
+
+	var kind string
+	switch {
+	case strings.HasPrefix(msg.Channel, "__keyspace@"):
+		kind = "keyspace"
+	case strings.HasPrefix(msg.Channel, "__keyevent@"):
+		kind = "keyevent"
+	default:
+		return ev, false
+	}
+
+	rest := msg.Channel[len("__"+kind+"@"):]
+	i := strings.Index(rest, "__:")
+	if i < 0 {
+		return ev, false
+	}
+
+	db, err := strconv.Atoi(rest[:i])
+	if err != nil {
+		return ev, false
+	}
+	ev.DB = db
+
+	if kind == "keyspace" {
+		ev.Key, ev.Event = rest[i+3:], string(msg.Message)
+	} else {
+		ev.Key, ev.Event = string(msg.Message), rest[i+3:]
+	}
+	return ev, true
+}
+
+// Events returns the chan the events are sent on, which is closed once ks is
+// closed. It has to be read from, otherwise receiving messages blocks.
+func (ks *KeyspaceSubscriber) Events() <-chan KeyspaceEvent {
+	return ks.events
+}
+
+// Close unsubscribes and closes the PubSub
+func (ks *KeyspaceSubscriber) Close() error {
+	err := ErrPubSubClosed
+	ks.closeOnce.Do(func() {
+		close(ks.closeCh)
+		<-ks.doneCh
+
+		// delivering messages blocks until the conn is closed
+		stop := make(chan struct{})
+		go func() {
+			for {
+				select {
+				case <-ks.msgCh:
+				case <-stop:
+					return
+				}
+			}
+		}()
+
+		err = ks.ps.Close()
+		close(stop)
+	})
+	return err
+}
@@ -0,0 +1,220 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+)
+
+// ErrLeaseReleased is returned by the conns leased with Pool.Lease once they
+// were released
+var ErrLeaseReleased = errors.New("retryableredis: lease released")
+
+// leasedConn is a conn from the pool leased with Lease
+type leasedConn struct {
+	pc *poolConn
+
+	mu       sync.Mutex
+	released bool
+	// broken is set if the conn gave up, and watching while a WATCH is active
+	broken   bool
+	watching bool
+
+	release func()
+}
+
+var _ Conn = (*leasedConn)(nil)
+
+// Lease takes a conn out of the pool until release is called, for sequences
+// of commands that have to run on the same conn like WATCH/MULTI/EXEC. It
+// waits for a conn to become available like Do.
+//
+// The conn performs actions with retries, and implements Conn. Closing it
+// releases it, after that its methods return ErrLeaseReleased. Conns that
+// gave up or were left with a different session state (e.g. after SELECT,
+// CLIENT REPLY OFF or a WATCH that wasn't ended) are replaced by a fresh one
+// when released.
+func (p *Pool) Lease(ctx context.Context) (conn radix.Conn, release func(), err error) {
+	pc, err := p.get(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	lc := &leasedConn{pc: pc}
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			lc.mu.Lock()
+			lc.released = true
+			dirty := lc.broken || lc.watching
+			lc.mu.Unlock()
+
+			if dirty || pc.sessionChanged() {
+				p.destroy(pc)
+				pc = p.newConn()
+			}
+			p.put(pc)
+		})
+	}
+	lc.release = release
+	return lc, release, nil
+}
+
+func (lc *leasedConn) Do(a radix.Action) error {
+	return lc.DoContext(context.Background(), a)
+}
+
+func (lc *leasedConn) DoContext(ctx context.Context, a radix.Action) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return ErrLeaseReleased
+	}
+
+	a = captureCmd(a)
+	names := actionCmdNames(a)
+	err := lc.pc.DoContext(ctx, a)
+	if connBroken(err) {
+		lc.broken = true
+	}
+	if err == nil {
+		for _, name := range names {
+			switch strings.ToUpper(name) {
+			case "WATCH":
+				lc.watching = true
+			case "UNWATCH", "EXEC", "DISCARD":
+				lc.watching = false
+			}
+		}
+	}
+	return err
+}
+
+// Watch runs the transaction on the leased conn, see Conn.Watch
+func (lc *leasedConn) Watch(rcv interface{}, keys []string, fn func(conn radix.Conn) error) error {
+	return lc.Do(&watchAction{rcv: rcv, keys: keys, fn: fn})
+}
+
+// Encode and Decode go straight to the leased conn, see Conn. They return
+// ErrLeaseReleased once it was released.
+func (lc *leasedConn) Encode(m resp.Marshaler) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return ErrLeaseReleased
+	}
+	return lc.pc.Encode(m)
+}
+
+func (lc *leasedConn) Decode(um resp.Unmarshaler) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return ErrLeaseReleased
+	}
+	return lc.pc.Decode(um)
+}
+
+// NetConn returns nil once the conn was released
+func (lc *leasedConn) NetConn() net.Conn {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return nil
+	}
+	return lc.pc.NetConn()
+}
+
+// SetAddr changes the address of the leased conn, it keeps it once released
+func (lc *leasedConn) SetAddr(network, addr string) error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return ErrLeaseReleased
+	}
+	return lc.pc.SetAddr(network, addr)
+}
+
+// UpdateConfig does nothing once the conn was released
+func (lc *leasedConn) UpdateConfig(fn func(*DialConfig)) {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return
+	}
+	lc.pc.UpdateConfig(fn)
+}
+
+// State returns StateClosed once the conn was released
+func (lc *leasedConn) State() State {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return StateClosed
+	}
+	return lc.pc.State()
+}
+
+func (lc *leasedConn) IsConnected() bool {
+	return lc.State() == StateConnected
+}
+
+// Stats returns the stats of the leased conn, or zero ones once it was
+// released
+func (lc *leasedConn) Stats() Stats {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return Stats{}
+	}
+	return lc.pc.Stats()
+}
+
+// LastError returns ErrLeaseReleased once the conn was released
+func (lc *leasedConn) LastError() error {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return ErrLeaseReleased
+	}
+	return lc.pc.LastError()
+}
+
+// Events returns nil once the conn was released
+func (lc *leasedConn) Events() <-chan Event {
+	lc.mu.Lock()
+	defer lc.mu.Unlock()
+	if lc.released {
+		return nil
+	}
+	return lc.pc.Events()
+}
+
+// Close releases the conn
+func (lc *leasedConn) Close() error {
+	lc.release()
+	return nil
+}
+
+// Shutdown releases the conn once the calls in progress are done, it's
+// returned to the pool rather than closed
+func (lc *leasedConn) Shutdown(ctx context.Context) (int, error) {
+	lc.release()
+	return 0, nil
+}
+
+// sessionChanged reports whether commands changed the session state of the
+// conn from what it's set up with when connecting
+func (rc *retryableRedisConn) sessionChanged() bool {
+	rc.lock <- struct{}{}
+	defer rc.unlock()
+
+	s := &rc.session
+	return s.db != "" || s.clientName != "" || s.readOnly ||
+		(s.clientReply != "" && s.clientReply != "ON") || s.replySkipped || s.multi || s.txnAborted
+}
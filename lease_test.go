@@ -0,0 +1,60 @@
+package retryableredis_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonas747/retryableredis"
+	"github.com/mediocregopher/radix/v3"
+)
+
+func TestLeaseReleased(t *testing.T) {
+	m := miniredis.RunT(t)
+	pool, err := retryableredis.NewPool(&retryableredis.PoolConfig{
+		Network: "tcp",
+		Addr:    m.Addr(),
+		Size:    1,
+		ConnConfig: retryableredis.DialConfig{
+			RetryBackoff:     testBackoff,
+			ReconnectBackoff: testBackoff,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pool.Close()
+
+	conn, release, err := pool.Lease(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := conn.Do(radix.Cmd(nil, "SET", "k", "v")); err != nil {
+		t.Fatal(err)
+	}
+	release()
+
+	// the conn is back in the pool, none of the methods may use it anymore
+	if err := conn.Do(radix.Cmd(nil, "GET", "k")); !errors.Is(err, retryableredis.ErrLeaseReleased) {
+		t.Fatalf("Do returned %v, want ErrLeaseReleased", err)
+	}
+	if err := conn.Encode(radix.Cmd(nil, "GET", "k")); !errors.Is(err, retryableredis.ErrLeaseReleased) {
+		t.Fatalf("Encode returned %v, want ErrLeaseReleased", err)
+	}
+	var v string
+	if err := conn.Decode(radix.Cmd(&v, "GET", "k")); !errors.Is(err, retryableredis.ErrLeaseReleased) {
+		t.Fatalf("Decode returned %v, want ErrLeaseReleased", err)
+	}
+	if nc := conn.NetConn(); nc != nil {
+		t.Fatalf("NetConn returned %v, want nil", nc)
+	}
+	rc := conn.(retryableredis.Conn)
+	if err := rc.Watch(nil, []string{"k"}, func(radix.Conn) error { return nil }); !errors.Is(err, retryableredis.ErrLeaseReleased) {
+		t.Fatalf("Watch returned %v, want ErrLeaseReleased", err)
+	}
+
+	if err := pool.Do(radix.Cmd(&v, "GET", "k")); err != nil || v != "v" {
+		t.Fatalf("GET returned %q, %v", v, err)
+	}
+}
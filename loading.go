@@ -0,0 +1,75 @@
+package retryableredis
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// LoadingProgress is the progress of the server loading its dataset into
+// memory, as reported by INFO persistence
+type LoadingProgress struct {
+	// Loading is false if the server finished loading in the meantime
+	Loading bool
+
+	Percent     float64
+	ETA         time.Duration
+	LoadedBytes int64
+	TotalBytes  int64
+}
+
+// loadingProgress queries the loading progress on a new connection, as the
+// main one has to stay free of anything but the actions performed on it
+func (conf *DialConfig) loadingProgress() (LoadingProgress, error) {
+	conn, err := conf.dial()
+	if err != nil {
+		return LoadingProgress{}, err
+	}
+	defer conn.Close()
+
+	var info string
+	if err := conn.Do(radix.Cmd(&info, "INFO", "persistence")); err != nil {
+		return LoadingProgress{}, err
+	}
+
+	return parseLoadingProgress(info), nil
+}
+
+func parseLoadingProgress(info string) LoadingProgress {
+	var p LoadingProgress
+	for _, line := range strings.Split(info, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		switch kv[0] {
+		case "loading":
+			p.Loading = kv[1] == "1"
+		case "loading_loaded_perc":
+			p.Percent, _ = strconv.ParseFloat(kv[1], 64)
+		case "loading_eta_seconds":
+			secs, _ := strconv.ParseInt(kv[1], 10, 64)
+			p.ETA = time.Duration(secs) * time.Second
+		case "loading_loaded_bytes":
+			p.LoadedBytes, _ = strconv.ParseInt(kv[1], 10, 64)
+		case "loading_total_bytes":
+			p.TotalBytes, _ = strconv.ParseInt(kv[1], 10, 64)
+		}
+	}
+
+	return p
+}
+
+// reportLoadingProgress calls OnLoadingProgress if it's set
+func (conf *DialConfig) reportLoadingProgress() {
+	if conf.OnLoadingProgress == nil {
+		return
+	}
+
+	if p, err := conf.loadingProgress(); err == nil {
+		conf.OnLoadingProgress(p)
+	}
+}
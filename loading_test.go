@@ -0,0 +1,51 @@
+package retryableredis_test
+
+import (
+	"testing"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+// newFlakyServer starts a FlakyServer that's closed when the test ends
+func newFlakyServer(t *testing.T) *retryableredistest.FlakyServer {
+	t.Helper()
+	s, err := retryableredistest.NewFlakyServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestLoadingRetried(t *testing.T) {
+	s := newFlakyServer(t)
+	s.Redis.Set("k", "v")
+
+	var cb callbacks
+	var retried []error
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{
+		ErrorBackoffs: map[string]retryableredis.Backoff{"LOADING": testBackoff},
+		OnRetry:       func(err error) { retried = append(retried, err) },
+	})
+
+	// the conn is dropped, after reconnecting the server is still loading
+	s.Restart(3)
+	var v string
+	if err := conn.Do(retryableredis.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+	cb.check(t)
+
+	if len(retried) != 3 {
+		t.Fatalf("OnRetry called %d times, want 3", len(retried))
+	}
+	for _, err := range retried {
+		if code := retryableredis.ErrorCode(err); code != "LOADING" {
+			t.Errorf("retried %q, want LOADING", code)
+		}
+	}
+}
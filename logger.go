@@ -0,0 +1,81 @@
+package retryableredis
+
+import (
+	"errors"
+	"strings"
+)
+
+// Logger receives structured log events about retries, reconnects and actions
+// that were given up on. The arguments are alternating keys and values.
+//
+// *slog.Logger implements it, see NewSlogLogger.
+type Logger interface {
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+}
+
+func (conf *DialConfig) logRetry(info RetryInfo) {
+	if conf.Logger == nil {
+		return
+	}
+
+	conf.Logger.Warn("retrying redis action",
+		"cmds", strings.Join(info.Cmds, ","),
+		"keys", info.Keys,
+		"attempt", info.Attempt,
+		"delay", info.Delay,
+		"class", info.Class.String(),
+		"err", info.Err)
+}
+
+func (conf *DialConfig) logReconnect(info RetryInfo) {
+	if conf.Logger == nil {
+		return
+	}
+
+	conf.Logger.Warn("reconnecting to redis",
+		"addr", conf.Addr,
+		"attempt", info.Attempt,
+		"delay", info.Delay,
+		"err", info.Err)
+}
+
+func (conf *DialConfig) logReadOnly(err error) {
+	if conf.Logger == nil {
+		return
+	}
+
+	conf.Logger.Warn("redis conn entering read-only mode",
+		"addr", conf.Addr,
+		"err", err)
+}
+
+func (conf *DialConfig) logSentinelWatch(err error) {
+	if conf.Logger == nil {
+		return
+	}
+
+	conf.Logger.Warn("subscribing to redis sentinel failed",
+		"master", conf.SentinelMaster,
+		"err", err)
+}
+
+// logGiveUp logs err if it means the action was given up on, rather than it
+// failing with an error reply or the context being done
+func (conf *DialConfig) logGiveUp(cmds []string, err error) {
+	if conf.Logger == nil || !gaveUp(err) {
+		return
+	}
+
+	conf.Logger.Error("giving up on redis action",
+		"cmds", strings.Join(cmds, ","),
+		"err", err)
+}
+
+// gaveUp reports whether err means retrying was given up on
+func gaveUp(err error) bool {
+	return errors.Is(err, ErrRetriesExhausted) ||
+		errors.Is(err, ErrAmbiguousResult) ||
+		errors.Is(err, ErrStillLoading) ||
+		errors.Is(err, ErrBudgetExhausted)
+}
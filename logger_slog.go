@@ -0,0 +1,17 @@
+//go:build go1.21
+// +build go1.21
+
+package retryableredis
+
+import "log/slog"
+
+var _ Logger = (*slog.Logger)(nil)
+
+// NewSlogLogger returns a Logger writing to l, or to slog.Default() if l is nil
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+
+	return l.With("component", "retryableredis")
+}
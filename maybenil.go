@@ -0,0 +1,48 @@
+package retryableredis
+
+import (
+	"github.com/mediocregopher/radix/v3"
+)
+
+// MaybeNilCmd is a Cmd or FlatCmd whose reply may be nil, made with
+// CmdMaybeNil or FlatCmdMaybeNil
+type MaybeNilCmd struct {
+	radix.CmdAction
+	mn radix.MaybeNil
+}
+
+// CmdMaybeNil is like Cmd, but a nil reply leaves rcv untouched and makes
+// IsNil return true
+func CmdMaybeNil(rcv interface{}, cmd string, args ...string) *MaybeNilCmd {
+	c := &MaybeNilCmd{mn: radix.MaybeNil{Rcv: rcv}}
+	c.CmdAction = Cmd(&c.mn, cmd, args...)
+	return c
+}
+
+// FlatCmdMaybeNil is like FlatCmd, but a nil reply leaves rcv untouched and
+// makes IsNil return true
+func FlatCmdMaybeNil(rcv interface{}, cmd, key string, args ...interface{}) *MaybeNilCmd {
+	c := &MaybeNilCmd{mn: radix.MaybeNil{Rcv: rcv}}
+	c.CmdAction = FlatCmd(&c.mn, cmd, key, args...)
+	return c
+}
+
+// IsNil reports whether the reply was nil, e.g a GET of a key that doesn't
+// exist
+func (c *MaybeNilCmd) IsNil() bool {
+	return c.mn.Nil
+}
+
+// ClusterCanRetry implements radix.ClusterCanRetryAction, like for Cmd
+func (c *MaybeNilCmd) ClusterCanRetry() bool {
+	return true
+}
+
+// Release releases the underlying cmd, see RetryableCmd.Release
+func (c *MaybeNilCmd) Release() {
+	Release(c.CmdAction)
+}
+
+func (c *MaybeNilCmd) unwrapCmd() radix.CmdAction {
+	return c.CmdAction
+}
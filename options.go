@@ -0,0 +1,234 @@
+package retryableredis
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// Option configures a conn dialed with DialAddr, it can also be used to set
+// fields that have no With function
+type Option func(*DialConfig)
+
+// DialAddr is like Dial, but configured using options instead of a DialConfig.
+// The network defaults to tcp.
+func DialAddr(addr string, opts ...Option) (Conn, error) {
+	conf := &DialConfig{
+		Network: "tcp",
+		Addr:    addr,
+	}
+	for _, opt := range opts {
+		opt(conf)
+	}
+
+	return Dial(conf)
+}
+
+// WithNetwork sets the network, e.g "unix"
+func WithNetwork(network string) Option {
+	return func(conf *DialConfig) {
+		conf.Network = network
+	}
+}
+
+// WithDialOpts adds options passed to radix.Dial
+func WithDialOpts(opts ...radix.DialOpt) Option {
+	return func(conf *DialConfig) {
+		conf.DialOpts = append(conf.DialOpts, opts...)
+	}
+}
+
+// WithDialer sets the Dialer used to open network connections
+func WithDialer(dialer func(ctx context.Context, network, addr string) (net.Conn, error)) Option {
+	return func(conf *DialConfig) {
+		conf.Dialer = dialer
+	}
+}
+
+// WithTLS connects using TLS, tlsConf can be nil to use the defaults
+func WithTLS(tlsConf *tls.Config) Option {
+	return func(conf *DialConfig) {
+		if tlsConf == nil {
+			tlsConf = &tls.Config{}
+		}
+		conf.TLSConfig = tlsConf
+	}
+}
+
+// WithAuth sets the credentials used to AUTH, username can be empty
+func WithAuth(username, password string) Option {
+	return func(conf *DialConfig) {
+		conf.Username = username
+		conf.Password = password
+	}
+}
+
+// WithCredentialsProvider sets the CredentialsProvider called for the AUTH
+// credentials on every connect
+func WithCredentialsProvider(fn func(ctx context.Context) (username, password string, err error)) Option {
+	return func(conf *DialConfig) {
+		conf.CredentialsProvider = fn
+	}
+}
+
+// WithDB sets the database selected on connect
+func WithDB(db int) Option {
+	return func(conf *DialConfig) {
+		conf.DB = db
+	}
+}
+
+// WithBackoff sets the backoff used for both retries and reconnects
+func WithBackoff(b Backoff) Option {
+	return func(conf *DialConfig) {
+		conf.RetryBackoff = b
+		conf.ReconnectBackoff = b
+	}
+}
+
+// WithRetryBackoff sets the backoff between retries
+func WithRetryBackoff(b Backoff) Option {
+	return func(conf *DialConfig) {
+		conf.RetryBackoff = b
+	}
+}
+
+// WithReconnectBackoff sets the backoff between reconnect attempts
+func WithReconnectBackoff(b Backoff) Option {
+	return func(conf *DialConfig) {
+		conf.ReconnectBackoff = b
+	}
+}
+
+// WithMaxRetries sets the max number of retries within a Do call
+func WithMaxRetries(n int) Option {
+	return func(conf *DialConfig) {
+		conf.MaxRetries = n
+	}
+}
+
+// WithMaxReconnectAttempts sets the max number of dial attempts when
+// reconnecting
+func WithMaxReconnectAttempts(n int) Option {
+	return func(conf *DialConfig) {
+		conf.MaxReconnectAttempts = n
+	}
+}
+
+// WithOnRetry sets the OnRetryInfo callback
+func WithOnRetry(fn func(RetryInfo)) Option {
+	return func(conf *DialConfig) {
+		conf.OnRetryInfo = fn
+	}
+}
+
+// WithOnReconnect sets the OnReconnectInfo callback
+func WithOnReconnect(fn func(RetryInfo)) Option {
+	return func(conf *DialConfig) {
+		conf.OnReconnectInfo = fn
+	}
+}
+
+// WithOnGiveUp sets the OnGiveUp callback
+func WithOnGiveUp(fn func(cmd string, args []string, err error)) Option {
+	return func(conf *DialConfig) {
+		conf.OnGiveUp = fn
+	}
+}
+
+// WithLogger sets the Logger
+func WithLogger(l Logger) Option {
+	return func(conf *DialConfig) {
+		conf.Logger = l
+	}
+}
+
+// WithCircuitBreaker sets the CircuitBreaker
+func WithCircuitBreaker(cb *CircuitBreaker) Option {
+	return func(conf *DialConfig) {
+		conf.CircuitBreaker = cb
+	}
+}
+
+// WithRetryBudget sets the RetryBudget
+func WithRetryBudget(b *RetryBudget) Option {
+	return func(conf *DialConfig) {
+		conf.RetryBudget = b
+	}
+}
+
+// WithCommandTimeout sets the CommandTimeout
+func WithCommandTimeout(d time.Duration) Option {
+	return func(conf *DialConfig) {
+		conf.CommandTimeout = d
+	}
+}
+
+// WithKeepAlive sets the KeepAliveInterval
+func WithKeepAlive(interval time.Duration) Option {
+	return func(conf *DialConfig) {
+		conf.KeepAliveInterval = interval
+	}
+}
+
+// WithLazyConnect makes DialAddr return without connecting
+func WithLazyConnect() Option {
+	return func(conf *DialConfig) {
+		conf.LazyConnect = true
+	}
+}
+
+// WithInterceptors appends to the Interceptors
+func WithInterceptors(interceptors ...func(next DoFunc) DoFunc) Option {
+	return func(conf *DialConfig) {
+		conf.Interceptors = append(conf.Interceptors, interceptors...)
+	}
+}
+
+// WithAutoPipeline sets the AutoPipelineWindow
+func WithAutoPipeline(window time.Duration) Option {
+	return func(conf *DialConfig) {
+		conf.AutoPipelineWindow = window
+	}
+}
+
+// WithSlowCommand sets the SlowThreshold and OnSlowCommand
+func WithSlowCommand(threshold time.Duration, fn func(cmd string, keys []string, dur time.Duration)) Option {
+	return func(conf *DialConfig) {
+		conf.SlowThreshold = threshold
+		conf.OnSlowCommand = fn
+	}
+}
+
+// WithPprofLabels sets PprofLabels
+func WithPprofLabels() Option {
+	return func(conf *DialConfig) {
+		conf.PprofLabels = true
+	}
+}
+
+// WithDeliveryMode sets the DeliveryMode
+func WithDeliveryMode(mode DeliveryMode) Option {
+	return func(conf *DialConfig) {
+		conf.DeliveryMode = mode
+	}
+}
+
+// WithWait sets the WaitReplicas and WaitTimeout
+func WithWait(replicas int, timeout time.Duration) Option {
+	return func(conf *DialConfig) {
+		conf.WaitReplicas = replicas
+		conf.WaitTimeout = timeout
+	}
+}
+
+// WithRetrySampler sets the RetrySampler and OnRetriesSuppressed
+func WithRetrySampler(s *RetrySampler, onSuppressed func(n int)) Option {
+	return func(conf *DialConfig) {
+		conf.RetrySampler = s
+		conf.OnRetriesSuppressed = onSuppressed
+	}
+}
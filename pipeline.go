@@ -0,0 +1,123 @@
+package retryableredis
+
+import (
+	"errors"
+	"io"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// Pipeline returns an Action which writes all the cmds in a single write, then
+// reads their responses, like radix.Pipeline.
+//
+// Unlike radix.Pipeline it keeps track of which cmds already got a response,
+// so when the connection fails midway and Do retries it, only the remaining
+// cmds are sent again instead of executing the earlier ones twice. Cmds that
+// got a retryable error reply (e.g LOADING) are sent again as well.
+//
+// Error replies don't stop the pipeline, the first one is returned after all
+// the cmds got a response.
+func Pipeline(cmds ...radix.CmdAction) radix.Action {
+	cmds = append([]radix.CmdAction(nil), cmds...)
+	for i, cmd := range cmds {
+		cmds[i] = captureCmd(cmd).(radix.CmdAction)
+	}
+	return &pipeline{
+		cmds:     cmds,
+		received: make([]bool, len(cmds)),
+		errs:     make([]error, len(cmds)),
+	}
+}
+
+type pipeline struct {
+	cmds     []radix.CmdAction
+	received []bool
+
+	// the first error reply received, and the error replies of every cmd
+	err  error
+	errs []error
+
+	// retryablePrefixes is set to the conn's RetryableErrorPrefixes before
+	// every attempt
+	retryablePrefixes []string
+}
+
+func (p *pipeline) Keys() []string {
+	m := map[string]bool{}
+	for _, cmd := range p.cmds {
+		for _, k := range cmd.Keys() {
+			m[k] = true
+		}
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+func (p *pipeline) Run(conn radix.Conn) error {
+	var pending pipelineCmds
+	for i, cmd := range p.cmds {
+		if !p.received[i] {
+			pending = append(pending, cmd)
+		}
+	}
+
+	if len(pending) < 1 {
+		return p.err
+	}
+
+	if err := conn.Encode(pending); err != nil {
+		return err
+	}
+
+	var retryErr error
+	for i, cmd := range p.cmds {
+		if p.received[i] {
+			continue
+		}
+
+		err := conn.Decode(cmd)
+		switch {
+		case err == nil:
+		case isRetryableCode(p.retryablePrefixes, ErrorCode(err)):
+			// not executed, needs to be sent again
+			retryErr = err
+			continue
+		case errors.As(err, new(resp2.Error)), errors.As(err, new(resp.ErrDiscarded)):
+			if p.err == nil {
+				p.err = err
+			}
+			p.errs[i] = err
+		default:
+			// the connection is in an unknown state, Do will reconnect and
+			// retry the cmds that haven't been received yet
+			return err
+		}
+
+		p.received[i] = true
+	}
+
+	if retryErr != nil {
+		return retryErr
+	}
+	return p.err
+}
+
+// pipelineCmds marshals all the cmds in one go, so they're written with a
+// single flush
+type pipelineCmds []radix.CmdAction
+
+func (pc pipelineCmds) MarshalRESP(w io.Writer) error {
+	for _, cmd := range pc {
+		if err := cmd.MarshalRESP(w); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,58 @@
+package retryableredis_test
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestPipelineResendsUnanswered(t *testing.T) {
+	var mu sync.Mutex
+	counts := map[string]int{}
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		if args[0] != "INCR" && args[0] != "SET" {
+			return retryableredistest.OK()
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		counts[args[1]]++
+		if args[0] == "SET" {
+			// the conn breaks after the INCRs got their replies
+			if counts[args[1]] == 1 {
+				return retryableredistest.Drop()
+			}
+			return retryableredistest.OK()
+		}
+		return retryableredistest.Value(counts[args[1]])
+	})
+	defer srv.Close()
+
+	var cb callbacks
+	conn := dialProxy(t, srv.Addr(), &cb, retryableredis.DialConfig{})
+
+	var a, b int
+	var c string
+	err := conn.Do(retryableredis.Pipeline(
+		retryableredis.Cmd(&a, "INCR", "a"),
+		retryableredis.Cmd(&b, "INCR", "b"),
+		retryableredis.Cmd(&c, "SET", "c", "v"),
+	))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cb.check(t)
+
+	// only SET is sent again, running the INCRs twice would change them
+	if a != 1 || b != 1 || c != "OK" {
+		t.Fatalf("got %d, %d, %q, want 1, 1, OK", a, b, c)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := map[string]int{"a": 1, "b": 1, "c": 2}; !reflect.DeepEqual(counts, want) {
+		t.Fatalf("the cmds were sent %v times, want %v", counts, want)
+	}
+}
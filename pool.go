@@ -0,0 +1,457 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ErrPoolClosed is returned when using a Pool after Close has been called
+var ErrPoolClosed = errors.New("retryableredis: pool is closed")
+
+// PoolConfig configures a Pool
+type PoolConfig struct {
+	Network, Addr string
+
+	// Size is the number of conns dialed by NewPool, defaults to 10
+	Size int
+
+	// MaxActive is the max number of open conns, defaults to Size. When
+	// it's larger than Size conns are added while all of them are in use,
+	// callers wait for a conn to be returned once there are MaxActive.
+	MaxActive int
+
+	// MaxIdleTime, if set, closes conns that weren't used for that long,
+	// leaving at least MinIdle idle conns in the pool. Idle conns are added
+	// to keep MinIdle of them if there's less.
+	MaxIdleTime time.Duration
+	MinIdle     int
+
+	// ConnConfig is the template for the DialConfig of every conn in the
+	// pool, its Network and Addr are overwritten. If it has no DialScheduler
+	// the conns share one spacing their dials DefaultDialInterval apart, and
+	// if it has no ReconnectCoordinator they share one too.
+	ConnConfig DialConfig
+
+	// OnReconnect and OnRetry are called with the id of the conn, in addition
+	// to the callbacks in ConnConfig
+	OnReconnect func(connID int, cause error)
+	OnRetry     func(connID int, err error)
+
+	// HedgeAfter enables hedged reads when set: read-only cmds made with Cmd
+	// or FlatCmd that got no reply after HedgeAfter are sent again on another
+	// conn, the first reply is used and the other attempt cancelled.
+	HedgeAfter time.Duration
+
+	// PingIdle, if set, makes conns that weren't used for that long get
+	// PINGed before being handed out. Conns failing it are replaced by a
+	// fresh one, so the command isn't the one finding out the connection
+	// died while idle.
+	PingIdle time.Duration
+}
+
+// Pool is a radix.Client holding up to MaxActive retryable conns.
+//
+// Unlike using ConnFunc with radix.Pool, which runs actions directly against
+// the Encode/Decode methods of the conns, this calls Do on the conns so the
+// actions actually get retried. Conns that give up (e.g. after running out of
+// reconnect attempts) are evicted and replaced by a fresh one.
+type Pool struct {
+	conf PoolConfig
+	pool chan *poolConn
+
+	mu     sync.RWMutex
+	closed bool
+	nextID int
+	// active is the number of open conns, idle or in use
+	active int
+
+	closeCh chan struct{}
+
+	statsMu      sync.Mutex
+	waits        uint64
+	waitDuration time.Duration
+	created      uint64
+	destroyed    uint64
+}
+
+type poolConn struct {
+	*retryableRedisConn
+	id int
+
+	// lastUsed is when the conn was last returned to the pool
+	lastUsed time.Time
+}
+
+var _ radix.Client = (*Pool)(nil)
+
+// NewPool dials conf.Size conns and returns a Pool holding them
+func NewPool(conf *PoolConfig) (*Pool, error) {
+	p := &Pool{
+		conf:    *conf,
+		closeCh: make(chan struct{}),
+	}
+	if p.conf.Size < 1 {
+		p.conf.Size = 10
+	}
+	if p.conf.MaxActive < 1 {
+		p.conf.MaxActive = p.conf.Size
+	} else if p.conf.Size > p.conf.MaxActive {
+		p.conf.Size = p.conf.MaxActive
+	}
+	if p.conf.ConnConfig.DialScheduler == nil {
+		p.conf.ConnConfig.DialScheduler = NewDialScheduler(DefaultDialInterval)
+	}
+	if p.conf.ConnConfig.ReconnectCoordinator == nil {
+		p.conf.ConnConfig.ReconnectCoordinator = NewReconnectCoordinator()
+	}
+
+	p.pool = make(chan *poolConn, p.conf.MaxActive)
+	for i := 0; i < p.conf.Size; i++ {
+		pc := p.newConn()
+		if err := pc.Reconnect(nil); err != nil {
+			pc.Close()
+			p.Close()
+			return nil, err
+		}
+		p.active++
+		p.pool <- pc
+	}
+
+	if p.conf.MaxIdleTime > 0 || p.conf.MinIdle > 0 {
+		go p.maintain()
+	}
+	return p, nil
+}
+
+func (p *Pool) newConn() *poolConn {
+	p.mu.Lock()
+	id := p.nextID
+	p.nextID++
+	p.mu.Unlock()
+
+	p.statsMu.Lock()
+	p.created++
+	p.statsMu.Unlock()
+
+	conf := p.conf.ConnConfig
+	conf.Network = p.conf.Network
+	conf.Addr = p.conf.Addr
+
+	onReconnect, onRetry := conf.OnReconnect, conf.OnRetry
+	conf.OnReconnect = func(cause error) {
+		if onReconnect != nil {
+			onReconnect(cause)
+		}
+		if p.conf.OnReconnect != nil {
+			p.conf.OnReconnect(id, cause)
+		}
+	}
+	conf.OnRetry = func(err error) {
+		if onRetry != nil {
+			onRetry(err)
+		}
+		if p.conf.OnRetry != nil {
+			p.conf.OnRetry(id, err)
+		}
+	}
+
+	return &poolConn{
+		retryableRedisConn: newConn(&conf),
+		id:                 id,
+		lastUsed:           time.Now(),
+	}
+}
+
+// destroy closes a conn taken out of the pool
+func (p *Pool) destroy(pc *poolConn) {
+	pc.Close()
+
+	p.statsMu.Lock()
+	p.destroyed++
+	p.statsMu.Unlock()
+}
+
+// get returns an idle conn, a new one if there's none and there are less than
+// MaxActive, or else waits for one to be returned
+func (p *Pool) get(ctx context.Context) (*poolConn, error) {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil, ErrPoolClosed
+	}
+	select {
+	case pc := <-p.pool:
+		p.mu.Unlock()
+		return p.checkIdle(ctx, pc), nil
+	default:
+	}
+	if p.active < p.conf.MaxActive {
+		// it connects on its first Do
+		p.active++
+		p.mu.Unlock()
+		return p.newConn(), nil
+	}
+	p.mu.Unlock()
+
+	started := time.Now()
+	defer func() {
+		p.statsMu.Lock()
+		p.waits++
+		p.waitDuration += time.Since(started)
+		p.statsMu.Unlock()
+	}()
+
+	select {
+	case pc, ok := <-p.pool:
+		if !ok {
+			return nil, ErrPoolClosed
+		}
+		return p.checkIdle(ctx, pc), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *Pool) put(pc *poolConn) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		p.active--
+		p.destroy(pc)
+		return
+	}
+
+	pc.lastUsed = time.Now()
+	p.pool <- pc
+}
+
+// checkIdle PINGs pc if it was idle for PingIdle, returning a fresh conn in its
+// place if that fails
+func (p *Pool) checkIdle(ctx context.Context, pc *poolConn) *poolConn {
+	if p.conf.PingIdle <= 0 || time.Since(pc.lastUsed) < p.conf.PingIdle {
+		return pc
+	}
+
+	// error replies mean the connection works
+	ping := WrapAction(Cmd(nil, "PING"), WithoutReconnect(), WithActionMaxAttempts(1))
+	err := pc.DoContext(ctx, ping)
+	if err == nil || errors.As(err, new(resp2.Error)) || ctx.Err() != nil {
+		return pc
+	}
+
+	p.destroy(pc)
+	pc = p.newConn()
+	// a failed dial is retried by the first Do
+	pc.Reconnect(nil)
+	return pc
+}
+
+// Do implements radix.Client
+func (p *Pool) Do(a radix.Action) error {
+	return p.DoContext(context.Background(), a)
+}
+
+// DoContext performs the action on a conn from the pool, waiting for one to
+// become available if needed. See Conn.DoContext.
+func (p *Pool) DoContext(ctx context.Context, a radix.Action) error {
+	if p.conf.HedgeAfter > 0 {
+		if args, rcv, ok := hedgeable(a); ok {
+			return p.doHedged(ctx, args, rcv)
+		}
+	}
+	return p.do(ctx, a)
+}
+
+// do performs the action on a single conn from the pool
+func (p *Pool) do(ctx context.Context, a radix.Action) error {
+	pc, err := p.get(ctx)
+	if err != nil {
+		return err
+	}
+
+	err = pc.DoContext(ctx, a)
+	p.putAfter(pc, err)
+	return err
+}
+
+// putAfter returns pc to the pool after an action on it returned err, replacing
+// it if err means it's broken
+func (p *Pool) putAfter(pc *poolConn, err error) {
+	if connBroken(err) {
+		p.destroy(pc)
+		pc = p.newConn()
+	}
+	p.put(pc)
+}
+
+// Watch runs an optimistic transaction on a conn from the pool, see Conn.Watch
+func (p *Pool) Watch(rcv interface{}, keys []string, fn func(conn radix.Conn) error) error {
+	return p.Do(&watchAction{rcv: rcv, keys: keys, fn: fn})
+}
+
+// connBroken reports whether err means the conn gave up and should be evicted
+func connBroken(err error) bool {
+	// conflicts mean the server is fine
+	if errors.Is(err, ErrWatchConflict) {
+		return false
+	}
+	if errors.Is(err, ErrRetriesExhausted) {
+		return true
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// NumAvailConns returns the number of conns currently available in the pool
+func (p *Pool) NumAvailConns() int {
+	return len(p.pool)
+}
+
+// Close closes all the conns in the pool, conns in use are closed once they
+// are returned
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPoolClosed
+	}
+	p.closed = true
+
+	close(p.closeCh)
+	close(p.pool)
+	for pc := range p.pool {
+		p.active--
+		p.destroy(pc)
+	}
+	return nil
+}
+
+// maintain closes conns idle for MaxIdleTime and adds conns to keep MinIdle
+// idle ones until the pool is closed
+func (p *Pool) maintain() {
+	interval := time.Second
+	if p.conf.MaxIdleTime > 0 && p.conf.MaxIdleTime/2 < interval {
+		interval = p.conf.MaxIdleTime / 2
+	}
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-t.C:
+		}
+
+		p.closeIdle()
+		p.fillIdle()
+	}
+}
+
+// closeIdle closes the conns idle for MaxIdleTime, as long as more than MinIdle
+// are idle
+func (p *Pool) closeIdle() {
+	if p.conf.MaxIdleTime <= 0 {
+		return
+	}
+
+	var expired []*poolConn
+	defer func() {
+		for _, pc := range expired {
+			p.destroy(pc)
+		}
+	}()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		return
+	}
+
+	// a waiting get takes conns from the pool without p.mu, so it may hold
+	// less than len(p.pool) by the time they're taken
+	idle := len(p.pool)
+	for i := len(p.pool); i > 0; i-- {
+		var pc *poolConn
+		select {
+		case pc = <-p.pool:
+		default:
+			return
+		}
+		if idle > p.conf.MinIdle && time.Since(pc.lastUsed) >= p.conf.MaxIdleTime {
+			idle--
+			p.active--
+			expired = append(expired, pc)
+			continue
+		}
+		p.pool <- pc
+	}
+}
+
+// fillIdle dials conns until MinIdle are idle or there are MaxActive
+func (p *Pool) fillIdle() {
+	for {
+		p.mu.Lock()
+		if p.closed || len(p.pool) >= p.conf.MinIdle || p.active >= p.conf.MaxActive {
+			p.mu.Unlock()
+			return
+		}
+		p.active++
+		p.mu.Unlock()
+
+		pc := p.newConn()
+		if err := pc.Reconnect(nil); err != nil {
+			p.mu.Lock()
+			p.active--
+			p.mu.Unlock()
+			p.destroy(pc)
+			return
+		}
+		p.put(pc)
+	}
+}
+
+// PoolStats holds statistics about a Pool, see Pool.Stats
+type PoolStats struct {
+	// Conns is the number of open conns, Idle of them are in the pool and
+	// InUse are being used
+	Conns, Idle, InUse int
+
+	// Waits is the number of times a conn had to be waited for because
+	// MaxActive were in use, WaitDuration is the total time spent waiting
+	Waits        uint64
+	WaitDuration time.Duration
+
+	// Created and Closed are the number of conns opened and closed since the
+	// pool was made, including the replacements of broken conns
+	Created, Closed uint64
+}
+
+// Stats returns statistics about the pool, the ones of its conns are
+// collected with the callbacks in ConnConfig
+func (p *Pool) Stats() PoolStats {
+	p.mu.RLock()
+	stats := PoolStats{
+		Conns: p.active,
+		Idle:  len(p.pool),
+	}
+	p.mu.RUnlock()
+	stats.InUse = stats.Conns - stats.Idle
+
+	p.statsMu.Lock()
+	stats.Waits = p.waits
+	stats.WaitDuration = p.waitDuration
+	stats.Created = p.created
+	stats.Closed = p.destroyed
+	p.statsMu.Unlock()
+	return stats
+}
@@ -0,0 +1,288 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// PubSubPingInterval is how often a PubSub pings the server to detect a lost
+// connection when it's otherwise idle
+const PubSubPingInterval = time.Second * 5
+
+// ErrPubSubClosed is returned when using a PubSub after Close has been called
+var ErrPubSubClosed = errors.New("retryableredis: pubsub is closed")
+
+// ErrUsePubSubAPI is returned by Do for SUBSCRIBE, PSUBSCRIBE and SSUBSCRIBE,
+// which would leave the conn unusable for anything else. Use PubSub instead.
+var ErrUsePubSubAPI = errors.New("retryableredis: subscribe through PubSub instead of Do")
+
+// subscribeCommands put a conn in pubsub mode
+var subscribeCommands = map[string]bool{
+	"SUBSCRIBE": true, "PSUBSCRIBE": true, "SSUBSCRIBE": true,
+}
+
+// hasSubscribeCmd reports whether any of names is one of subscribeCommands
+func hasSubscribeCmd(names []string) bool {
+	for _, name := range names {
+		if subscribeCommands[strings.ToUpper(name)] {
+			return true
+		}
+	}
+	return false
+}
+
+type subSet map[string]map[chan<- radix.PubSubMessage]bool
+
+func (ss subSet) add(s string, ch chan<- radix.PubSubMessage) {
+	if ss[s] == nil {
+		ss[s] = map[chan<- radix.PubSubMessage]bool{}
+	}
+	ss[s][ch] = true
+}
+
+func (ss subSet) del(s string, ch chan<- radix.PubSubMessage) {
+	delete(ss[s], ch)
+	if len(ss[s]) == 0 {
+		delete(ss, s)
+	}
+}
+
+// byChan returns the subscriptions grouped by the chan receiving them
+func (ss subSet) byChan() map[chan<- radix.PubSubMessage][]string {
+	res := map[chan<- radix.PubSubMessage][]string{}
+	for s, chs := range ss {
+		for ch := range chs {
+			res[ch] = append(res[ch], s)
+		}
+	}
+	return res
+}
+
+// PubSub is a radix.PubSubConn which reconnects when the connection is lost,
+// re-subscribing to all the channels and patterns it was subscribed to.
+//
+// Messages published while it was reconnecting are lost.
+type PubSub struct {
+	conf *DialConfig
+
+	// setup, if set, is run on every new conn before it's used for pubsub
+	setup func(radix.Conn) error
+
+	mu     sync.Mutex
+	inner  radix.PubSubConn
+	subs   subSet
+	psubs  subSet
+	closed bool
+
+	closeOnce sync.Once
+	closeCh   chan struct{}
+}
+
+var _ radix.PubSubConn = (*PubSub)(nil)
+
+// NewPubSub dials a new PubSub, using the OnReconnect callback and reconnect
+// settings in conf
+func NewPubSub(conf *DialConfig) (*PubSub, error) {
+	return newPubSub(conf, nil)
+}
+
+func newPubSub(conf *DialConfig, setup func(radix.Conn) error) (*PubSub, error) {
+	p := &PubSub{
+		conf:    conf,
+		setup:   setup,
+		subs:    subSet{},
+		psubs:   subSet{},
+		closeCh: make(chan struct{}),
+	}
+
+	conn, err := p.dial()
+	if err != nil {
+		return nil, err
+	}
+	p.inner = radix.PubSub(conn)
+
+	go p.pingLoop()
+	return p, nil
+}
+
+// dial dials a new conn and runs OnConnect and setup on it
+func (p *PubSub) dial() (radix.Conn, error) {
+	conn, err := p.conf.dial()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.conf.OnConnect != nil {
+		if err := p.conf.OnConnect(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	if p.setup != nil {
+		if err := p.setup(conn); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (p *PubSub) pingLoop() {
+	t := time.NewTicker(PubSubPingInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-p.closeCh:
+			return
+		case <-t.C:
+			p.Ping()
+		}
+	}
+}
+
+// reconnect replaces the inner conn and re-subscribes, p.mu has to be held
+func (p *PubSub) reconnect(cause error) error {
+	p.inner.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-p.closeCh:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	policy := p.conf.reconnectPolicy()
+	info := p.conf.newRetryInfo(ctx, nil, cause)
+	for attempt := 0; ; attempt++ {
+		info.Attempt = attempt + 1
+		p.conf.onReconnect(info)
+
+		err := p.resubscribe()
+		if err == nil {
+			return nil
+		}
+
+		delay, ok := policy.NextDelay(attempt, err)
+		if !ok {
+			return &RetriesExhaustedError{Attempts: attempt + 1, Err: err}
+		}
+
+		info.Err = err
+		info.Class = ErrorReconnect
+		info.Code = ErrorCode(err)
+		info.Delay = delay
+		if err := sleep(ctx, delay); err != nil {
+			return ErrPubSubClosed
+		}
+	}
+}
+
+func (p *PubSub) resubscribe() error {
+	conn, err := p.dial()
+	if err != nil {
+		return err
+	}
+
+	p.inner = radix.PubSub(conn)
+	for ch, channels := range p.subs.byChan() {
+		if err := p.inner.Subscribe(ch, channels...); err != nil {
+			p.inner.Close()
+			return err
+		}
+	}
+	for ch, patterns := range p.psubs.byChan() {
+		if err := p.inner.PSubscribe(ch, patterns...); err != nil {
+			p.inner.Close()
+			return err
+		}
+	}
+
+	return nil
+}
+
+// do runs fn against the inner conn, reconnecting if it fails
+func (p *PubSub) do(fn func(radix.PubSubConn) error) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.closed {
+		return ErrPubSubClosed
+	}
+
+	if err := fn(p.inner); err != nil {
+		return p.reconnect(err)
+	}
+	return nil
+}
+
+// Subscribe implements radix.PubSubConn
+func (p *PubSub) Subscribe(msgCh chan<- radix.PubSubMessage, channels ...string) error {
+	return p.do(func(inner radix.PubSubConn) error {
+		for _, c := range channels {
+			p.subs.add(c, msgCh)
+		}
+		return inner.Subscribe(msgCh, channels...)
+	})
+}
+
+// Unsubscribe implements radix.PubSubConn
+func (p *PubSub) Unsubscribe(msgCh chan<- radix.PubSubMessage, channels ...string) error {
+	return p.do(func(inner radix.PubSubConn) error {
+		for _, c := range channels {
+			p.subs.del(c, msgCh)
+		}
+		return inner.Unsubscribe(msgCh, channels...)
+	})
+}
+
+// PSubscribe implements radix.PubSubConn
+func (p *PubSub) PSubscribe(msgCh chan<- radix.PubSubMessage, patterns ...string) error {
+	return p.do(func(inner radix.PubSubConn) error {
+		for _, pattern := range patterns {
+			p.psubs.add(pattern, msgCh)
+		}
+		return inner.PSubscribe(msgCh, patterns...)
+	})
+}
+
+// PUnsubscribe implements radix.PubSubConn
+func (p *PubSub) PUnsubscribe(msgCh chan<- radix.PubSubMessage, patterns ...string) error {
+	return p.do(func(inner radix.PubSubConn) error {
+		for _, pattern := range patterns {
+			p.psubs.del(pattern, msgCh)
+		}
+		return inner.PUnsubscribe(msgCh, patterns...)
+	})
+}
+
+// Ping implements radix.PubSubConn, it reconnects if the ping fails
+func (p *PubSub) Ping() error {
+	return p.do(func(inner radix.PubSubConn) error {
+		return inner.Ping()
+	})
+}
+
+// Close implements radix.PubSubConn
+func (p *PubSub) Close() error {
+	err := ErrPubSubClosed
+	p.closeOnce.Do(func() {
+		// stops any reconnect loop in progress so the lock can be acquired
+		close(p.closeCh)
+
+		p.mu.Lock()
+		defer p.mu.Unlock()
+
+		p.closed = true
+		err = p.inner.Close()
+	})
+	return err
+}
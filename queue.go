@@ -0,0 +1,136 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// ErrQueueFull is returned by Do when OfflineQueueSize calls are already
+// waiting for the conn to reconnect
+var ErrQueueFull = errors.New("retryableredis: offline queue is full")
+
+// offlineQueue holds the Do calls made while reconnecting, releasing them one
+// at a time and in order once reconnected. A nil queue never queues.
+type offlineQueue struct {
+	mu   sync.Mutex
+	size int
+
+	// offline is set while reconnecting, flushing while the queued calls are
+	// being released
+	offline  bool
+	flushing bool
+	waiting  []chan struct{}
+}
+
+func newOfflineQueue(size int) *offlineQueue {
+	if size <= 0 {
+		return nil
+	}
+	return &offlineQueue{size: size}
+}
+
+// wait queues the call if the conn is reconnecting, and waits for its turn.
+// The returned func has to be called once the first attempt was made, to let
+// the next call through.
+func (q *offlineQueue) wait(ctx context.Context) (func(), error) {
+	noop := func() {}
+	if q == nil {
+		return noop, nil
+	}
+
+	q.mu.Lock()
+	if !q.offline && !q.flushing {
+		q.mu.Unlock()
+		return noop, nil
+	}
+	if len(q.waiting) >= q.size {
+		q.mu.Unlock()
+		return noop, ErrQueueFull
+	}
+	ch := make(chan struct{})
+	q.waiting = append(q.waiting, ch)
+	q.mu.Unlock()
+
+	released := false
+	release := func() {
+		if !released {
+			released = true
+			q.next()
+		}
+	}
+
+	select {
+	case <-ch:
+		// the deadline may have passed while it was our turn already, a
+		// stale call isn't worth replaying
+		if err := ctx.Err(); err != nil {
+			release()
+			return noop, err
+		}
+		return release, nil
+	case <-ctx.Done():
+	}
+
+	q.mu.Lock()
+	for i, w := range q.waiting {
+		if w == ch {
+			q.waiting = append(q.waiting[:i], q.waiting[i+1:]...)
+			q.mu.Unlock()
+			return noop, ctx.Err()
+		}
+	}
+	q.mu.Unlock()
+
+	// it was our turn already, pass it on
+	release()
+	return noop, ctx.Err()
+}
+
+// next lets the next queued call through, or ends the flush if there are none
+func (q *offlineQueue) next() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.waiting) == 0 {
+		q.flushing = false
+		return
+	}
+	close(q.waiting[0])
+	q.waiting = q.waiting[1:]
+}
+
+// setState starts queueing calls while reconnecting, and releases them once
+// the conn is connected again or gives up
+func (q *offlineQueue) setState(s State) {
+	if q == nil {
+		return
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	switch s {
+	case StateReconnecting:
+		q.offline = true
+	case StateConnected:
+		if !q.offline {
+			return
+		}
+		q.offline = false
+		if len(q.waiting) > 0 {
+			q.flushing = true
+			close(q.waiting[0])
+			q.waiting = q.waiting[1:]
+		}
+	default:
+		// reconnecting gave up or the conn was closed, there's no order to
+		// keep anymore
+		q.offline = false
+		q.flushing = false
+		for _, ch := range q.waiting {
+			close(ch)
+		}
+		q.waiting = nil
+	}
+}
@@ -0,0 +1,123 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// ReplicatedConfig configures a Replicated client
+type ReplicatedConfig struct {
+	// Primary is the address of the primary, Replicas the addresses of its
+	// replicas. Network defaults to tcp.
+	Network  string
+	Primary  string
+	Replicas []string
+
+	// PoolConfig is the template for the pool to every node, its Network and
+	// Addr are overwritten
+	PoolConfig PoolConfig
+}
+
+// Replicated is a radix.Client for a primary with replicas, it sends the
+// actions that only read (see IsReadOnlyCommand) to the replicas in turn and
+// everything else to the primary. Reads fail over to the next replica and
+// finally the primary when a replica is unavailable, after it was retried as
+// usual.
+//
+// Replicas lag behind the primary, so reads may not reflect recent writes.
+type Replicated struct {
+	primary  *Pool
+	replicas []*Pool
+	next     uint32
+}
+
+var _ radix.Client = (*Replicated)(nil)
+
+// NewReplicated connects to the primary and the replicas in conf
+func NewReplicated(conf *ReplicatedConfig) (*Replicated, error) {
+	r := &Replicated{}
+
+	primary, err := newNodePool(conf, conf.Primary)
+	if err != nil {
+		return nil, err
+	}
+	r.primary = primary
+
+	for _, addr := range conf.Replicas {
+		replica, err := newNodePool(conf, addr)
+		if err != nil {
+			r.Close()
+			return nil, err
+		}
+		r.replicas = append(r.replicas, replica)
+	}
+
+	return r, nil
+}
+
+func newNodePool(conf *ReplicatedConfig, addr string) (*Pool, error) {
+	poolConf := conf.PoolConfig
+	poolConf.Network = conf.Network
+	if poolConf.Network == "" {
+		poolConf.Network = "tcp"
+	}
+	poolConf.Addr = addr
+	return NewPool(&poolConf)
+}
+
+// Do implements radix.Client
+func (r *Replicated) Do(a radix.Action) error {
+	return r.DoContext(context.Background(), a)
+}
+
+// DoContext performs the action on a replica if it only reads, otherwise on
+// the primary
+func (r *Replicated) DoContext(ctx context.Context, a radix.Action) error {
+	if len(r.replicas) < 1 || !isReadOnlyAction(a) {
+		return r.primary.DoContext(ctx, a)
+	}
+
+	start := int(atomic.AddUint32(&r.next, 1))
+	for i := range r.replicas {
+		replica := r.replicas[(start+i)%len(r.replicas)]
+		err := replica.DoContext(ctx, a)
+		if !replicaUnavailable(err) {
+			return err
+		}
+		if ctx.Err() != nil {
+			return err
+		}
+	}
+
+	return r.primary.DoContext(ctx, a)
+}
+
+// replicaUnavailable reports whether err means the read should be tried on
+// another node
+func replicaUnavailable(err error) bool {
+	return connBroken(err) || errors.Is(err, ErrCircuitOpen) ||
+		errors.Is(err, ErrNotConnected) || errors.Is(err, ErrBudgetExhausted)
+}
+
+// Primary returns the pool to the primary, e.g for reads that need to reflect
+// the latest writes
+func (r *Replicated) Primary() *Pool {
+	return r.primary
+}
+
+// Close closes the pools to all the nodes
+func (r *Replicated) Close() error {
+	var err error
+	if r.primary != nil {
+		err = r.primary.Close()
+	}
+	for _, replica := range r.replicas {
+		if replicaErr := replica.Close(); err == nil {
+			err = replicaErr
+		}
+	}
+	return err
+}
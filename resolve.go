@@ -0,0 +1,70 @@
+package retryableredis
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// dialTarget is an address to dial, host is the name it was resolved from
+// which is used to verify TLS certificates
+type dialTarget struct {
+	addr, host string
+}
+
+// resolve returns the addresses to dial for addr, looking it up again if
+// LookupSRV or Resolver is set
+func (conf *DialConfig) resolve(addr string) ([]dialTarget, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || conf.Network == "unix" {
+		return []dialTarget{{addr: addr, host: addr}}, nil
+	}
+
+	resolver := conf.Resolver
+	if resolver == nil {
+		if !conf.LookupSRV {
+			return []dialTarget{{addr: addr, host: host}}, nil
+		}
+		resolver = net.DefaultResolver
+	}
+
+	ctx, cancel := conf.dialContext()
+	defer cancel()
+	if conf.LookupSRV {
+		_, srvs, err := resolver.LookupSRV(ctx, "", "", host)
+		if err != nil {
+			return nil, fmt.Errorf("retryableredis: looking up SRV records of %s: %w", host, err)
+		}
+
+		if len(srvs) == 0 {
+			return nil, fmt.Errorf("retryableredis: no SRV records for %s", host)
+		}
+
+		// already sorted by priority
+		targets := make([]dialTarget, 0, len(srvs))
+		for _, srv := range srvs {
+			target := strings.TrimSuffix(srv.Target, ".")
+			targets = append(targets, dialTarget{
+				addr: net.JoinHostPort(target, strconv.Itoa(int(srv.Port))),
+				host: target,
+			})
+		}
+		return targets, nil
+	}
+
+	if net.ParseIP(host) != nil {
+		return []dialTarget{{addr: addr, host: host}}, nil
+	}
+
+	ips, err := resolver.LookupHost(ctx, host)
+	if err != nil {
+		return nil, fmt.Errorf("retryableredis: looking up %s: %w", host, err)
+	}
+
+	targets := make([]dialTarget, 0, len(ips))
+	for _, ip := range ips {
+		targets = append(targets, dialTarget{addr: net.JoinHostPort(ip, port), host: host})
+	}
+	return targets, nil
+}
@@ -0,0 +1,32 @@
+package retryableredis
+
+import (
+	"github.com/mediocregopher/radix/v3"
+)
+
+// Retryable returns an Action that can be retried safely no matter what
+// newAction returns, e.g radix.WithConn, radix.Pipeline or an EvalScript
+// action. Every attempt creates a fresh action with newAction and runs it, as
+// radix's own actions can't be reused once they were performed. newAction is
+// also called to inspect the action, so it should do nothing but create it.
+//
+// Whatever the previous attempts did is not undone: if a failed attempt ran
+// some of its commands before the connection broke they're run again by the
+// next one, so the commands have to be safe to run more than once (see
+// IsIdempotentCommand), or the results of a WithConn have to be checked in its
+// callback. Use Pipeline for pipelines that resume where they failed instead.
+func Retryable(newAction func() radix.Action) radix.Action {
+	return &retryableAction{newAction: newAction}
+}
+
+type retryableAction struct {
+	newAction func() radix.Action
+}
+
+func (r *retryableAction) Keys() []string {
+	return r.newAction().Keys()
+}
+
+func (r *retryableAction) Run(conn radix.Conn) error {
+	return r.newAction().Run(conn)
+}
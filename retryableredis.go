@@ -1,230 +1,1714 @@
-package retryableredis
-
-import (
-	"bufio"
-	"io"
-	"net"
-	"strings"
-	"time"
-
-	"github.com/mediocregopher/radix/v3"
-	"github.com/mediocregopher/radix/v3/resp"
-)
-
-type retryableRedisConn struct {
-	inner radix.Conn
-
-	conf *DialConfig
-}
-
-type DialConfig struct {
-	Network, Addr string
-	OnReconnect   func(error)
-	OnRetry       func(error)
-	DialOpts      []radix.DialOpt
-}
-
-func Dial(conf *DialConfig) (radix.Conn, error) {
-	rc := &retryableRedisConn{
-		conf: conf,
-	}
-
-	err := rc.Reconnect(nil)
-	return rc, err
-}
-
-func ConnFunc(onReconnect func(error), onRetry func(error)) radix.ConnFunc {
-	return func(network, addr string) (radix.Conn, error) {
-		return Dial(&DialConfig{
-			Network: network,
-			Addr:    addr,
-
-			OnReconnect: onReconnect,
-			OnRetry:     onRetry,
-		})
-	}
-}
-
-func (rc *retryableRedisConn) Reconnect(cause error) error {
-	if rc.inner != nil {
-		rc.inner.Close()
-
-	}
-
-	if rc.conf.OnReconnect != nil {
-		rc.conf.OnReconnect(cause)
-	}
-
-	inner, err := radix.Dial(rc.conf.Network, rc.conf.Addr, rc.conf.DialOpts...)
-	rc.inner = inner
-	return err
-}
-
-func (rc *retryableRedisConn) ReconnectLoop(cause error) error {
-	for {
-		err := rc.Reconnect(cause)
-		if err == nil {
-			return nil
-		}
-
-		// update cause
-		cause = err
-		time.Sleep(time.Millisecond * 500)
-	}
-}
-
-// Do performs an Action, returning any error.
-func (rc *retryableRedisConn) Do(a radix.Action) error {
-	for {
-
-		err := rc.inner.Do(a)
-		if err == nil {
-			return nil
-		}
-
-		// reconnect on io errors
-		if _, ok := err.(net.Error); ok {
-			rc.ReconnectLoop(err)
-			continue
-		}
-
-		// retry on loading errors
-		if strings.HasPrefix(err.Error(), "LOADING") {
-			if rc.conf.OnRetry != nil {
-				rc.conf.OnRetry(err)
-			}
-			time.Sleep(time.Millisecond * 250)
-			continue
-		}
-
-		return err
-	}
-}
-
-// Once Close() is called all future method calls on the Client will return
-// an error
-func (rc *retryableRedisConn) Close() error {
-	return rc.inner.Close()
-}
-
-func (rc *retryableRedisConn) Encode(m resp.Marshaler) error {
-	return rc.inner.Encode(m)
-}
-
-func (rc *retryableRedisConn) Decode(um resp.Unmarshaler) error {
-	return rc.inner.Decode(um)
-}
-
-// Returns the underlying network connection, as-is. Read, Write, and Close
-// should not be called on the returned Conn.
-func (rc *retryableRedisConn) NetConn() net.Conn {
-	return rc.inner.NetConn()
-}
-
-func FlatCmd(rcv interface{}, cmd, key string, args ...interface{}) radix.CmdAction {
-	retryableCmd := &RetryableFlatCmd{
-		rcv:  rcv,
-		cmd:  cmd,
-		key:  key,
-		args: args,
-
-		inner: radix.FlatCmd(rcv, cmd, key, args...),
-	}
-
-	return retryableCmd
-}
-
-type RetryableFlatCmd struct {
-	rcv  interface{}
-	cmd  string
-	key  string
-	args []interface{}
-
-	inner radix.CmdAction
-}
-
-func (r *RetryableFlatCmd) getInner() radix.CmdAction {
-	if r.inner == nil {
-		r.inner = radix.FlatCmd(r.rcv, r.cmd, r.key, r.args...)
-	}
-
-	return r.inner
-}
-
-func (r *RetryableFlatCmd) Keys() []string {
-	return r.getInner().Keys()
-}
-
-func (r *RetryableFlatCmd) Run(conn radix.Conn) error {
-	if err := conn.Encode(r); err != nil {
-		return err
-	}
-
-	return conn.Decode(r)
-}
-
-func (c *RetryableFlatCmd) MarshalRESP(w io.Writer) error {
-	return c.getInner().MarshalRESP(w)
-}
-
-func (c *RetryableFlatCmd) UnmarshalRESP(br *bufio.Reader) error {
-	err := c.getInner().UnmarshalRESP(br)
-
-	// can't reuse the action after this
-	c.inner = nil
-
-	return err
-}
-
-func Cmd(rcv interface{}, cmd string, args ...string) radix.CmdAction {
-	retryableCmd := &RetryableCmd{
-		rcv:  rcv,
-		cmd:  cmd,
-		args: args,
-
-		inner: radix.Cmd(rcv, cmd, args...),
-	}
-
-	return retryableCmd
-}
-
-type RetryableCmd struct {
-	rcv  interface{}
-	cmd  string
-	key  string
-	args []string
-
-	inner radix.CmdAction
-}
-
-func (r *RetryableCmd) getInner() radix.CmdAction {
-	if r.inner == nil {
-		r.inner = radix.Cmd(r.rcv, r.cmd, r.args...)
-	}
-
-	return r.inner
-}
-
-func (r *RetryableCmd) Keys() []string {
-	return r.getInner().Keys()
-}
-
-func (r *RetryableCmd) Run(conn radix.Conn) error {
-	if err := conn.Encode(r); err != nil {
-		return err
-	}
-	return conn.Decode(r)
-}
-
-func (c *RetryableCmd) MarshalRESP(w io.Writer) error {
-	return c.getInner().MarshalRESP(w)
-}
-
-func (c *RetryableCmd) UnmarshalRESP(br *bufio.Reader) error {
-	err := c.getInner().UnmarshalRESP(br)
-
-	// can't reuse the action after this
-	c.inner = nil
-
-	return err
-}
+package retryableredis
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"runtime/pprof"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// Conn is a radix.Conn which retries and reconnects on errors.
+type Conn interface {
+	radix.Conn
+
+	// DoContext performs an Action like Do, but gives up on retrying and
+	// reconnecting once ctx is done, returning ctx.Err()
+	DoContext(ctx context.Context, a radix.Action) error
+
+	// State returns the current state of the conn
+	State() State
+
+	// Stats returns statistics about the conn
+	Stats() Stats
+
+	// IsConnected reports whether the state of the conn is StateConnected
+	IsConnected() bool
+
+	// LastError returns the last error returned by an attempt or a dial
+	LastError() error
+
+	// SetAddr changes the address of the server and connects to it
+	SetAddr(network, addr string) error
+
+	// Watch runs an optimistic WATCH/MULTI/EXEC transaction, retrying it if
+	// a watched key was modified. fn reads the keys and queues the writes
+	// after sending MULTI, Watch sends EXEC and unmarshals its reply into
+	// rcv.
+	Watch(rcv interface{}, keys []string, fn func(conn radix.Conn) error) error
+
+	// UpdateConfig changes the config of the conn at runtime, fn is called
+	// with a copy of the current config which then replaces it. Changes
+	// take effect on the next attempt, retry or reconnect. Slices and maps
+	// in the config are shared with the previous one, replace them instead
+	// of modifying them.
+	//
+	// OfflineQueueSize can't be changed, and the background checks for
+	// KeepAliveInterval, MaxConnLifetime and Addrs only run if they were
+	// enabled when dialing.
+	UpdateConfig(fn func(*DialConfig))
+
+	// Shutdown stops new Do calls, which return ErrClosed, waits for the
+	// ones in progress to finish, including the ones in the offline queue,
+	// and closes the conn. If ctx is done first the calls still in progress
+	// are abandoned, failing with ErrClosed, and their number is returned.
+	Shutdown(ctx context.Context) (abandoned int, err error)
+
+	// Events returns a channel receiving the retries, reconnects, give ups
+	// and closing of the conn, in addition to the callbacks. Events are
+	// dropped while it's full, see EventBufferSize.
+	Events() <-chan Event
+}
+
+type retryableRedisConn struct {
+	// lock is held while using or replacing inner, it's a channel so waiting
+	// for it can be cancelled
+	lock  chan struct{}
+	inner radix.Conn
+	// gen is incremented every time inner is replaced
+	gen uint64
+	// addrIdx is the index in Addrs of the address inner is connected to
+	addrIdx int
+	// failbackAt is when to check if a preferred address is back
+	failbackAt time.Time
+	// expiresAt is when inner reaches MaxConnLifetime
+	expiresAt time.Time
+	// reconnectedAt is when the last reconnect succeeded, after
+	// reconnectAttempts attempts since the conn was last healthy (see
+	// HealthyPeriod)
+	reconnectedAt     time.Time
+	reconnectAttempts int
+	// readOnlyGen is the generation of the connection the conn entered
+	// read-only mode on, readOnly is set while in it (see
+	// DegradeOnReadOnly)
+	readOnly    bool
+	readOnlyGen uint64
+
+	stateMu sync.Mutex
+	state   State
+
+	stats   connStats
+	traffic *trafficCounters
+
+	// session is guarded by lock
+	session sessionState
+	// db is the DB selected by the session, for reading it without lock
+	dbMu sync.Mutex
+	db   string
+
+	queue        *offlineQueue
+	autoPipeline autoPipeline
+	coalescer    coalescer
+
+	// bgReconnecting is set while reconnecting in the background in
+	// FailFast mode
+	bgReconnecting int32
+
+	// calls is the number of Do calls in progress, draining is set by
+	// Shutdown and drained closed once calls reaches 0 after that
+	callsMu  sync.Mutex
+	calls    int
+	draining bool
+	drained  chan struct{}
+
+	// inFlight is the connection an attempt is being performed on, so
+	// Shutdown can abort it without waiting for the lock
+	inFlightMu sync.Mutex
+	inFlight   radix.Conn
+
+	// events is made by the first Events call, eventsClosed is set once
+	// the conn is closed
+	eventsMu     sync.Mutex
+	events       chan Event
+	eventsClosed bool
+
+	// closeCh is closed by Close
+	closeOnce sync.Once
+	closeCh   chan struct{}
+
+	// conf holds a *DialConfig, which is replaced as a whole when changed.
+	// confMu is held while replacing it.
+	confMu sync.Mutex
+	conf   atomic.Value
+}
+
+type DialConfig struct {
+	Network, Addr string
+	OnReconnect   func(error)
+	OnRetry       func(error)
+	DialOpts      []radix.DialOpt
+
+	// Dialer, if set, is used to open the network connection on every
+	// (re)connect instead of net.Dial, e.g to go through a proxy. DialOpts
+	// aren't used in that case, use Username, Password, DB and the TLS
+	// fields instead.
+	Dialer func(ctx context.Context, network, addr string) (net.Conn, error)
+
+	// DialTimeout, if set, limits every dial including reconnects: looking
+	// up the address, connecting and the AUTH and SELECT after. Without it
+	// dials through Dialer can hang for as long as the OS takes to give up
+	// on connecting, e.g to a blackholed IP.
+	DialTimeout time.Duration
+
+	// Addrs, if set, is used instead of Addr. Connecting tries them in
+	// order, and while connected to any but the first a better one is
+	// tried every FailbackInterval, switching to it once it's reachable.
+	Addrs []string
+
+	// FailbackInterval is how often to try failing back to a preferred
+	// address in Addrs, DefaultFailbackInterval is used if not set
+	FailbackInterval time.Duration
+
+	// Resolver, if set, is used to look up the host of the address on every
+	// (re)connect instead of leaving it to the dialer, every address it
+	// resolves to is tried in order
+	Resolver *net.Resolver
+
+	// LookupSRV makes the host of Addr be looked up as an SRV record on
+	// every (re)connect, its targets are tried in order of priority. The
+	// port of Addr is ignored in that case.
+	LookupSRV bool
+
+	// Username and Password, if set, are used to AUTH every new connection,
+	// Username requires redis 6 ACLs
+	Username, Password string
+
+	// CredentialsProvider, if set, is called for the credentials to AUTH
+	// with on every new connection instead of using Username and Password,
+	// so that short-lived tokens (e.g IAM auth) can be refreshed. An empty
+	// password skips AUTH.
+	CredentialsProvider func(ctx context.Context) (username, password string, err error)
+
+	// DB, if set, is selected on every new connection
+	DB int
+
+	// ReplayTransactions makes a MULTI transaction that was open when the
+	// connection broke be opened again with the commands queued so far after
+	// reconnecting. Otherwise the commands up to EXEC or DISCARD return
+	// ErrTxnAborted, so that half a transaction never runs outside of it.
+	ReplayTransactions bool
+
+	// Scripts, if set, are loaded on every new connection before it's used
+	Scripts *ScriptRegistry
+
+	// TLSConfig, if set, makes connections use TLS. TLSCAFile, TLSCertFile
+	// and TLSKeyFile are added to a copy of it, they're loaded again on
+	// every reconnect so rotated certificates are picked up. Setting any of
+	// the TLS fields enables TLS.
+	TLSConfig *tls.Config
+
+	// TLSCAFile is a PEM file with the CAs to verify the server with,
+	// instead of the system ones
+	TLSCAFile string
+
+	// TLSCertFile and TLSKeyFile are PEM files with the client certificate
+	TLSCertFile, TLSKeyFile string
+
+	// TLSServerName overrides the server name verified, it defaults to
+	// the host being dialled
+	TLSServerName string
+
+	// TLSInsecureSkipVerify disables verifying the server certificate
+	TLSInsecureSkipVerify bool
+
+	// ReconnectBackoff controls the delays between reconnect attempts,
+	// DefaultReconnectBackoff is used if not set
+	ReconnectBackoff Backoff
+
+	// RetryBackoff controls the delays between retries, DefaultRetryBackoff
+	// is used if not set
+	RetryBackoff Backoff
+
+	// ErrorBackoffs overrides RetryBackoff for retrying error replies with
+	// the given prefixes (e.g "CLUSTERDOWN" or "TRYAGAIN"), falling back to
+	// DefaultErrorBackoffs
+	ErrorBackoffs map[string]Backoff
+
+	// MaxRetries is the max number of times an action is retried within a
+	// single Do call, 0 means no limit
+	MaxRetries int
+
+	// JoinAttemptErrors makes the errors returned after retries include the
+	// errors of all attempts, which errors.Is and errors.As match as well,
+	// instead of only the final one. errors.As still finds the AttemptsError.
+	JoinAttemptErrors bool
+
+	// RetryableErrorPrefixes are the codes of the error replies that are
+	// retried (see ErrorCode), e.g for proxies or modules with their own
+	// transient errors. DefaultRetryableErrorPrefixes if nil, append to it
+	// to extend them.
+	RetryableErrorPrefixes []string
+
+	// MaxReconnectAttempts is the max number of dial attempts made when
+	// reconnecting, 0 means no limit
+	MaxReconnectAttempts int
+
+	// RetryPolicy, if set, is used instead of RetryBackoff and MaxRetries.
+	// The delay it returns is not used when the retry follows a reconnect.
+	RetryPolicy RetryPolicy
+
+	// ReconnectPolicy, if set, is used instead of ReconnectBackoff and
+	// MaxReconnectAttempts
+	ReconnectPolicy RetryPolicy
+
+	// CircuitBreaker, if set, makes Do fail fast with ErrCircuitOpen while
+	// the server keeps failing
+	CircuitBreaker *CircuitBreaker
+
+	// HealthyPeriod, if set, is how long the conn has to stay connected
+	// before the reconnect backoff starts over, until then a reconnect
+	// continues from the delay the previous one ended at. Failures counted
+	// by the CircuitBreaker are also only forgotten after none happened for
+	// this long. This keeps a flapping server from getting instant
+	// reconnects every time it comes back for a moment.
+	HealthyPeriod time.Duration
+
+	// RetryBudget, if set, limits the rate of retries and reconnect attempts,
+	// Do returns ErrBudgetExhausted instead of retrying once it runs out. It
+	// can be shared between multiple conns.
+	RetryBudget *RetryBudget
+
+	// DialScheduler, if set, staggers the dials made while reconnecting with
+	// the other conns sharing it. Pools set one up by default.
+	DialScheduler *DialScheduler
+
+	// ReconnectCoordinator, if set, makes only one of the conns sharing it
+	// dial an address that's down, the reconnects of the others wait for it
+	// to succeed. Pools set one up by default.
+	ReconnectCoordinator *ReconnectCoordinator
+
+	// SentinelAddrs, if set, are queried for the address of SentinelMaster
+	// on every reconnect, Addr is ignored in that case
+	SentinelAddrs  []string
+	SentinelMaster string
+
+	// SentinelDialOpts are used when connecting to the sentinels
+	SentinelDialOpts []radix.DialOpt
+
+	// SentinelWatch makes the conn subscribe to the +switch-master
+	// announcements of the sentinels, reconnecting to the new master as
+	// soon as SentinelMaster fails over instead of once commands fail.
+	// Announcements made while the subscription is reconnecting are missed.
+	// Failing to subscribe is logged to Logger and retried with
+	// ReconnectBackoff. Every conn subscribes separately, including the ones
+	// of a Pool.
+	SentinelWatch bool
+
+	// RetryOnlyIdempotent makes Do return an AmbiguousResultError instead of
+	// retrying after a network error, unless all the commands in the action
+	// are known to be safe to run twice (see IsIdempotentCommand).
+	RetryOnlyIdempotent bool
+
+	// DeliveryMode is AtLeastOnce by default. With AtMostOnce commands that
+	// were fully written before a network error aren't retried, whether they
+	// are idempotent or not, unless they were made with IdempotentCmd.
+	DeliveryMode DeliveryMode
+
+	// WaitReplicas, if set, makes every write outside of a MULTI followed by
+	// a WAIT for that many replicas to acknowledge it, for up to WaitTimeout
+	// (0 means forever). A ReplicationError is returned if not enough did.
+	// If the WAIT itself fails a ReplicationUnconfirmedError is returned,
+	// the write isn't retried as it succeeded.
+	WaitReplicas int
+	WaitTimeout  time.Duration
+
+	// OnRetryInfo and OnReconnectInfo are like OnRetry and OnReconnect, but
+	// get details about the action and attempt. OnRetryInfo is called for
+	// every retry, including the ones after a reconnect.
+	OnRetryInfo     func(RetryInfo)
+	OnReconnectInfo func(RetryInfo)
+
+	// OnGiveUp is called when retrying an action was given up on, e.g
+	// after MaxRetries, once for every command in it with its arguments, so
+	// the command can be dead-lettered or re-enqueued elsewhere. args is nil
+	// for actions whose arguments can't be determined.
+	OnGiveUp func(cmd string, args []string, err error)
+
+	// OnDo is called after every Do call, and OnDial after every dial
+	// attempt, including the initial one
+	OnDo   func(DoInfo)
+	OnDial func(DialInfo)
+
+	// OnConnect, if set, is run on every new connection before it's used,
+	// including the ones made by reconnects, for setup commands like CLIENT
+	// SETNAME or CLIENT NO-EVICT. conn is the underlying connection, which
+	// doesn't retry. An error fails the connect, which is retried like a
+	// failed dial.
+	OnConnect func(conn radix.Conn) error
+
+	// OnSlowCommand is called for every Do call that took at least
+	// SlowThreshold, including the time spent retrying and reconnecting. cmd
+	// is the names of the commands joined by commas.
+	SlowThreshold time.Duration
+	OnSlowCommand func(cmd string, keys []string, dur time.Duration)
+
+	// PprofLabels labels the goroutine with the command name as "redis_cmd"
+	// during Do calls, so CPU and goroutine profiles show which commands are
+	// hot or stuck retrying. Actions with several commands are labeled
+	// "PIPELINE".
+	PprofLabels bool
+
+	// OnDoStart is called at the start of every Do call, the context it
+	// returns is used for the rest of the call and passed to the other
+	// callbacks in RetryInfo and DoInfo, e.g for tracing
+	OnDoStart func(ctx context.Context, a radix.Action) context.Context
+
+	// Interceptors wrap every Do call around the whole retry loop, e.g for
+	// logging or authorization. AttemptInterceptors wrap every attempt, they
+	// are called with the conn locked so they mustn't use it. The first one
+	// is the outermost.
+	Interceptors        []func(next DoFunc) DoFunc
+	AttemptInterceptors []func(next DoFunc) DoFunc
+
+	// Logger, if set, is used to log retries, reconnects and actions that
+	// were given up on
+	Logger Logger
+
+	// RetrySampler, if set, limits the rate of retries reported through
+	// OnRetry and the Logger. OnRetriesSuppressed is called once a second
+	// with the number of retries that weren't reported, if any.
+	RetrySampler        *RetrySampler
+	OnRetriesSuppressed func(n int)
+
+	// OnStateChange is called whenever the state of the conn changes, cause
+	// is the error that caused it, if any
+	OnStateChange func(old, new State, cause error)
+
+	// LazyConnect makes Dial return without connecting, the first Do call
+	// connects instead (reconnecting according to the reconnect policy if
+	// that fails). This allows starting up while the server is unreachable.
+	LazyConnect bool
+
+	// ReconnectOnReadOnly makes READONLY errors, returned when writing to a
+	// replica, reconnect and retry instead of being returned. This is useful
+	// when the address can point at a demoted master after a failover, the
+	// master is looked up again through Sentinel (or DNS) when reconnecting.
+	ReconnectOnReadOnly bool
+
+	// DegradeOnReadOnly makes READONLY and MASTERDOWN errors, returned
+	// during a failover, put the conn in read-only mode: writes fail with
+	// ErrReadOnlyMode without being attempted, while reads are performed as
+	// usual. The next write attempted after a reconnect is performed, and
+	// the mode ends once a write succeeds.
+	DegradeOnReadOnly bool
+
+	// MaxLoadingWait is the max time a Do call waits for the server while it
+	// responds with LOADING errors before returning a StillLoadingError, 0
+	// means no limit. The delay between attempts is controlled by
+	// RetryBackoff or RetryPolicy. Calls whose context deadline passes
+	// before the next attempt get the StillLoadingError right away.
+	MaxLoadingWait time.Duration
+
+	// OnLoadingProgress, if set, is called with the progress of the server
+	// loading its dataset every time a LOADING error is retried. The
+	// progress is queried on a separate connection.
+	OnLoadingProgress func(LoadingProgress)
+
+	// MaxMasterDownWait is the max time a Do call keeps retrying MASTERDOWN
+	// errors, returned by replicas that lost their master when
+	// replica-serve-stale-data is disabled, 0 means no limit
+	MaxMasterDownWait time.Duration
+
+	// RetryBusy makes BUSY errors, returned while a lua script is running,
+	// retryable
+	RetryBusy bool
+
+	// BusyScriptKillAfter, if set, sends SCRIPT KILL on a separate
+	// connection after BUSY errors have been retried for this long
+	BusyScriptKillAfter time.Duration
+
+	// ClassifyError, if set, decides how errors returned by attempts are
+	// handled instead of the built-in logic (DefaultClassifyError and the
+	// ReconnectOnReadOnly and RetryBusy options), e.g for servers that use
+	// different error messages
+	ClassifyError func(error) ErrorClass
+
+	// CommandTimeout, if set, is the max time an attempt waits for the
+	// server to respond. The connection is closed once it passes, so that a
+	// connection that silently died doesn't hang Do until the kernel gives up
+	// on it, and the attempt fails with ErrCommandTimeout which causes a
+	// reconnect.
+	//
+	// Blocking commands made with Cmd (BLPOP, XREAD BLOCK etc.) get the time
+	// they block for on top, or no timeout if they block forever. When they
+	// are retried after a reconnect they block for the time they have left.
+	CommandTimeout time.Duration
+
+	// ReadTimeout and WriteTimeout, if set, are applied as deadlines on the
+	// network connection for every attempt, going from when the attempt
+	// starts. Unlike CommandTimeout they're enforced by the connection
+	// itself. Passing a deadline fails the attempt with a timeout error,
+	// which causes a reconnect. ReadTimeout is extended for
+	// blocking commands like CommandTimeout is. They replace the read and
+	// write timeouts radix sets when dialing. Without ReadTimeout radix's
+	// read timeout (10s by default, see radix.DialReadTimeout) applies to
+	// every read, blocking commands get the time they block for on top.
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	// KeepAliveInterval, if set, makes the conn PING the server this often
+	// while it's not in use, reconnecting right away if the
+	// connection turns out to be dead instead of on the next Do call. This
+	// keeps idle connections behind NATs and load balancers alive.
+	KeepAliveInterval time.Duration
+
+	// MaxConnLifetime, if set, is the max time a connection is used for,
+	// after which the conn dials a new one and closes the old one once it's
+	// not in use. This picks up DNS changes after failovers and maintenance.
+	// Lifetimes are shortened by up to 10% at random so that conns dialed
+	// together don't all redial at once.
+	MaxConnLifetime time.Duration
+
+	// FailFast makes Do return ErrNotConnected right away while the conn
+	// isn't connected, because the initial dial or a reconnect loop failed,
+	// instead of reconnecting first. The conn reconnects in the background
+	// meanwhile.
+	FailFast bool
+
+	// OfflineQueueSize, if set, makes Do calls made while the conn is
+	// reconnecting wait in a queue of up to this many calls, they're then
+	// performed in order once reconnected. Do returns ErrQueueFull when the
+	// queue is full. Calls whose ctx is done leave the queue with ctx.Err()
+	// rather than being sent once reconnected.
+	OfflineQueueSize int
+
+	// AutoPipelineWindow, if set, makes Do calls for single Cmds and
+	// FlatCmds wait this long for concurrent calls, which are then sent
+	// together in a Pipeline (so only the ones that didn't get a reply are
+	// retried). Cmds that change the state of the conn or block aren't
+	// pipelined. AutoPipelineLimit is the max number of cmds in a pipeline,
+	// which is sent right away once it's reached, it defaults to
+	// DefaultAutoPipelineLimit.
+	AutoPipelineWindow time.Duration
+	AutoPipelineLimit  int
+
+	// CoalesceReads makes concurrent Do calls for identical reads (e.g GET
+	// of the same key) made with Cmd or FlatCmd share a single one, which
+	// saves work while the conn is retrying or under load. A read that
+	// starts while an identical one is in flight gets its reply, which may
+	// not reflect writes made meanwhile. Each caller stops waiting once its
+	// own ctx is done, the shared read goes on for the others.
+	CoalesceReads bool
+
+	// StaleCache, if set, caches the replies to the reads CoalesceReads
+	// applies to. When such a read fails with anything but an error reply,
+	// e.g after running out of retries during an outage, the cached reply
+	// is used and a StaleResultError returned.
+	StaleCache *StaleCache
+
+	// EventBufferSize is the size of the channel returned by Events,
+	// DefaultEventBufferSize if 0
+	EventBufferSize int
+}
+
+func (conf *DialConfig) retryPolicy() RetryPolicy {
+	if conf.RetryPolicy != nil {
+		return conf.RetryPolicy
+	}
+
+	p := BackoffPolicy{Backoff: conf.RetryBackoff.orDefault(DefaultRetryBackoff)}
+	if conf.MaxRetries > 0 {
+		p.MaxAttempts = conf.MaxRetries + 1
+	}
+	return p
+}
+
+func (conf *DialConfig) reconnectPolicy() RetryPolicy {
+	if conf.ReconnectPolicy != nil {
+		return conf.ReconnectPolicy
+	}
+
+	return BackoffPolicy{
+		Backoff:     conf.ReconnectBackoff.orDefault(DefaultReconnectBackoff),
+		MaxAttempts: conf.MaxReconnectAttempts,
+	}
+}
+
+func Dial(conf *DialConfig) (Conn, error) {
+	rc := newConn(conf)
+	if conf.LazyConnect {
+		return rc, nil
+	}
+
+	err := rc.Reconnect(nil)
+	return rc, err
+}
+
+// newConn returns a conn that isn't connected yet, it will connect on first use
+func newConn(conf *DialConfig) *retryableRedisConn {
+	rc := &retryableRedisConn{
+		lock:    make(chan struct{}, 1),
+		queue:   newOfflineQueue(conf.OfflineQueueSize),
+		traffic: new(trafficCounters),
+		closeCh: make(chan struct{}),
+	}
+	// changes to conf after dialing aren't picked up
+	c := *conf
+	rc.conf.Store(&c)
+	rc.autoPipeline.rc = rc
+	if conf.KeepAliveInterval > 0 || conf.MaxConnLifetime > 0 || len(conf.Addrs) > 1 {
+		go rc.maintain()
+	}
+	if conf.SentinelWatch && len(conf.SentinelAddrs) > 0 {
+		go rc.watchSentinel()
+	}
+	return rc
+}
+
+// config returns the current config, it must not be modified
+func (rc *retryableRedisConn) config() *DialConfig {
+	return rc.conf.Load().(*DialConfig)
+}
+
+// UpdateConfig replaces the config with a copy modified by fn
+func (rc *retryableRedisConn) UpdateConfig(fn func(*DialConfig)) {
+	rc.confMu.Lock()
+	defer rc.confMu.Unlock()
+
+	c := *rc.config()
+	fn(&c)
+	rc.conf.Store(&c)
+}
+
+// SetAddr changes the address connected to, replacing Addrs and SentinelAddrs,
+// and connects to it right away. If that fails the current connection is kept
+// until it breaks, reconnecting connects to the new address either way.
+func (rc *retryableRedisConn) SetAddr(network, addr string) error {
+	rc.UpdateConfig(func(conf *DialConfig) {
+		conf.Network = network
+		conf.Addr = addr
+		conf.Addrs = nil
+		conf.SentinelAddrs = nil
+	})
+
+	if err := rc.lockCtx(context.Background()); err != nil {
+		return err
+	}
+	defer rc.unlock()
+
+	inner, addrIdx, err := rc.dialSession(-1)
+	rc.stats.dialed(rc.gen == 0, err)
+	if err != nil {
+		return err
+	}
+
+	if rc.inner != nil {
+		rc.inner.Close()
+	}
+	rc.setInner(inner, addrIdx)
+	rc.setState(StateConnected, nil)
+	return nil
+}
+
+func ConnFunc(onReconnect func(error), onRetry func(error)) radix.ConnFunc {
+	return func(network, addr string) (radix.Conn, error) {
+		return Dial(&DialConfig{
+			Network: network,
+			Addr:    addr,
+
+			OnReconnect: onReconnect,
+			OnRetry:     onRetry,
+		})
+	}
+}
+
+// Reconnect closes the current connection and dials a new one
+func (rc *retryableRedisConn) Reconnect(cause error) error {
+	if err := rc.lockCtx(context.Background()); err != nil {
+		return err
+	}
+	defer rc.unlock()
+
+	info := rc.config().newRetryInfo(context.Background(), nil, cause)
+	info.Attempt = 1
+	err := rc.reconnect(info)
+	if err != nil {
+		rc.setState(StateDegraded, err)
+	}
+	return err
+}
+
+// lockCtx acquires rc.lock, giving up once ctx is done. It returns ErrClosed
+// without holding the lock once the conn is closed.
+func (rc *retryableRedisConn) lockCtx(ctx context.Context) error {
+	select {
+	case rc.lock <- struct{}{}:
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rc.closeCh:
+		return ErrClosed
+	}
+
+	if rc.isClosed() {
+		rc.unlock()
+		return ErrClosed
+	}
+	return nil
+}
+
+func (rc *retryableRedisConn) unlock() {
+	<-rc.lock
+}
+
+// reconnect replaces inner with a new connection, rc.lock has to be held
+func (rc *retryableRedisConn) reconnect(info RetryInfo) error {
+	if rc.inner != nil {
+		rc.inner.Close()
+	}
+
+	rc.setState(StateReconnecting, info.Err)
+	rc.config().onReconnect(info)
+	// the initial connect isn't a reconnect
+	initial := rc.gen == 0 && info.Err == nil
+	if !initial {
+		rc.emit(EventReconnecting, info)
+	}
+
+	inner, addrIdx, err := rc.dialSession(-1)
+	rc.stats.dialed(rc.gen == 0, err)
+	rc.setInner(inner, addrIdx)
+	if err == nil {
+		rc.setState(StateConnected, nil)
+		if !initial {
+			rc.emit(EventReconnected, RetryInfo{Cmds: info.Cmds, Attempt: info.Attempt, Err: info.Err})
+		}
+	}
+	return err
+}
+
+// dialSession dials a new connection and sets up the session state on it,
+// returning the index of the address it connected to. Only the first n
+// addresses are tried, unless n is -1. rc.lock has to be held.
+func (rc *retryableRedisConn) dialSession(n int) (radix.Conn, int, error) {
+	started := time.Now()
+	inner, addrIdx, err := rc.config().dialFirst(n)
+	if rc.config().OnDial != nil {
+		rc.config().OnDial(DialInfo{Duration: time.Since(started), Err: err})
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if inner, err = countTraffic(inner, rc.traffic); err != nil {
+		return nil, 0, err
+	}
+
+	// before replaying the session, which may turn off replies
+	if err = rc.config().Scripts.load(inner); err != nil {
+		inner.Close()
+		return nil, 0, err
+	}
+	if err = rc.session.replay(inner, rc.config().ReplayTransactions); err != nil {
+		inner.Close()
+		return nil, 0, err
+	}
+	if onConnect := rc.config().OnConnect; onConnect != nil {
+		if err = onConnect(inner); err != nil {
+			inner.Close()
+			return nil, 0, err
+		}
+	}
+	return inner, addrIdx, nil
+}
+
+// setInner replaces inner, rc.lock has to be held
+func (rc *retryableRedisConn) setInner(inner radix.Conn, addrIdx int) {
+	rc.inner = inner
+	rc.addrIdx = addrIdx
+	rc.gen++
+	if inner != nil && rc.config().MaxConnLifetime > 0 {
+		rc.expiresAt = time.Now().Add(rc.config().connLifetime())
+	}
+}
+
+// dial creates a new underlying connection
+func (conf *DialConfig) dial() (radix.Conn, error) {
+	conn, _, err := conf.dialFirst(-1)
+	return conn, err
+}
+
+// addrs returns the addresses to connect to, in order of priority
+func (conf *DialConfig) addrs() ([]string, error) {
+	if len(conf.SentinelAddrs) > 0 {
+		addr, err := conf.masterAddr()
+		if err != nil {
+			return nil, err
+		}
+		return []string{addr}, nil
+	}
+
+	if len(conf.Addrs) > 0 {
+		return conf.Addrs, nil
+	}
+	return []string{conf.Addr}, nil
+}
+
+// dialFirst connects to the first of the first n addresses that can be
+// connected to, returning its index. All of them are tried if n is -1.
+func (conf *DialConfig) dialFirst(n int) (radix.Conn, int, error) {
+	addrs, err := conf.addrs()
+	if err != nil {
+		return nil, 0, err
+	}
+	if n >= 0 && n < len(addrs) {
+		addrs = addrs[:n]
+	}
+
+	for i, addr := range addrs {
+		var conn radix.Conn
+		if conn, err = conf.dialOne(addr); err == nil {
+			return conn, i, nil
+		}
+	}
+	return nil, 0, err
+}
+
+// dialOne connects to a single address
+func (conf *DialConfig) dialOne(addr string) (radix.Conn, error) {
+	targets, err := conf.resolve(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// try the addresses in order
+	var conn radix.Conn
+	for _, target := range targets {
+		if conn, err = conf.dialAddr(target); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := conf.setupConn(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return conn, nil
+}
+
+// dialAddr opens a connection to a single address
+func (conf *DialConfig) dialAddr(target dialTarget) (radix.Conn, error) {
+	tlsConf, err := conf.tlsConfig()
+	if err != nil {
+		return nil, err
+	}
+	if tlsConf != nil && tlsConf.ServerName == "" {
+		tlsConf.ServerName = target.host
+	}
+
+	if conf.Dialer != nil {
+		return conf.dialCustom(target.addr, tlsConf)
+	}
+
+	opts := conf.DialOpts
+	if tlsConf != nil {
+		opts = append(opts[:len(opts):len(opts)], radix.DialUseTLS(tlsConf))
+	}
+	if conf.DialTimeout > 0 {
+		opts = append(opts[:len(opts):len(opts)], radix.DialConnectTimeout(conf.DialTimeout))
+	}
+	// radix would override the deadlines on every read and write
+	if conf.ReadTimeout > 0 {
+		opts = append(opts[:len(opts):len(opts)], radix.DialReadTimeout(0))
+	}
+	if conf.WriteTimeout > 0 {
+		opts = append(opts[:len(opts):len(opts)], radix.DialWriteTimeout(0))
+	}
+	return radix.Dial(conf.Network, target.addr, opts...)
+}
+
+// dialCustom opens a connection using conf.Dialer
+func (conf *DialConfig) dialCustom(addr string, tlsConf *tls.Config) (radix.Conn, error) {
+	ctx, cancel := conf.dialContext()
+	defer cancel()
+
+	netConn, err := conf.Dialer(ctx, conf.Network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tlsConf != nil {
+		tlsConn := tls.Client(netConn, tlsConf)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			netConn.Close()
+			return nil, err
+		}
+		netConn = tlsConn
+	}
+
+	return radix.NewConn(netConn), nil
+}
+
+// dialContext returns the context for a single dial, which is done once
+// DialTimeout passes
+func (conf *DialConfig) dialContext() (context.Context, context.CancelFunc) {
+	if conf.DialTimeout > 0 {
+		return context.WithTimeout(context.Background(), conf.DialTimeout)
+	}
+	return context.WithCancel(context.Background())
+}
+
+// setupConn authenticates and selects the database on a new connection
+func (conf *DialConfig) setupConn(conn radix.Conn) error {
+	if netConn := conn.NetConn(); netConn != nil && conf.DialTimeout > 0 {
+		netConn.SetDeadline(time.Now().Add(conf.DialTimeout))
+		defer netConn.SetDeadline(time.Time{})
+	}
+
+	username, password := conf.Username, conf.Password
+	if conf.CredentialsProvider != nil {
+		var err error
+		username, password, err = conf.CredentialsProvider(context.Background())
+		if err != nil {
+			return fmt.Errorf("retryableredis: getting credentials: %w", err)
+		}
+	}
+
+	if password != "" {
+		args := []string{password}
+		if username != "" {
+			args = []string{username, password}
+		}
+		if err := conn.Do(radix.Cmd(nil, "AUTH", args...)); err != nil {
+			return err
+		}
+	}
+
+	if conf.DB != 0 {
+		if err := conn.Do(radix.Cmd(nil, "SELECT", strconv.Itoa(conf.DB))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReconnectLoop reconnects until it succeeds or the reconnect policy gives up
+func (rc *retryableRedisConn) ReconnectLoop(cause error) error {
+	if err := rc.lockCtx(context.Background()); err != nil {
+		return err
+	}
+	defer rc.unlock()
+
+	return rc.reconnectLoop(context.Background(), rc.config().newRetryInfo(context.Background(), nil, cause))
+}
+
+// reconnectLoop is ReconnectLoop, rc.lock has to be held. info describes what
+// caused the reconnect.
+func (rc *retryableRedisConn) reconnectLoop(ctx context.Context, info RetryInfo) (err error) {
+	policy := rc.config().reconnectPolicy()
+	defer func() {
+		if err != nil {
+			rc.config().ReconnectCoordinator.release(rc.config().Addr, rc)
+		}
+	}()
+
+	// the backoff continues if the conn hasn't been healthy since the last
+	// reconnect
+	prevAttempts := 0
+	if period := rc.config().HealthyPeriod; period > 0 && time.Since(rc.reconnectedAt) < period {
+		prevAttempts = rc.reconnectAttempts
+	}
+	if prevAttempts > 0 {
+		if delay, ok := policy.NextDelay(prevAttempts-1, info.Err); ok {
+			if err := rc.sleep(ctx, delay); err != nil {
+				return err
+			}
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		if rc.isClosed() {
+			return ErrClosed
+		}
+		if err := rc.sleep(ctx, rc.config().DialScheduler.reserve()); err != nil {
+			return err
+		}
+		if !rc.config().CircuitBreaker.allow() {
+			return ErrCircuitOpen
+		}
+
+		info.Attempt = attempt + 1
+		err := rc.coordinatedReconnect(ctx, info)
+		rc.config().CircuitBreaker.record(err != nil, rc.config().HealthyPeriod)
+		if err == nil {
+			rc.reconnectedAt = time.Now()
+			rc.reconnectAttempts = prevAttempts + attempt + 1
+			return nil
+		}
+
+		delay, ok := policy.NextDelay(attempt, err)
+		if prevAttempts > 0 && ok {
+			if d, ok := policy.NextDelay(prevAttempts+attempt, err); ok && d > delay {
+				delay = d
+			}
+		}
+		if !ok {
+			rc.setState(StateDegraded, err)
+			return &RetriesExhaustedError{Attempts: attempt + 1, Err: err}
+		}
+		if !rc.config().RetryBudget.take() {
+			rc.setState(StateDegraded, err)
+			return ErrBudgetExhausted
+		}
+
+		// update cause
+		info.Err = err
+		info.Class = ErrorReconnect
+		info.Code = ErrorCode(err)
+		info.Delay = delay
+		if err := rc.sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// reconnectFrom reconnects unless inner was already replaced since generation
+// gen, so that when multiple goroutines see the same broken connection only
+// one of them reconnects
+func (rc *retryableRedisConn) reconnectFrom(ctx context.Context, gen uint64, info RetryInfo) error {
+	if err := rc.lockCtx(ctx); err != nil {
+		return err
+	}
+	defer rc.unlock()
+
+	if rc.gen != gen && rc.inner != nil {
+		return nil
+	}
+
+	return rc.reconnectLoop(ctx, info)
+}
+
+// discard closes inner if it's still generation gen, the next attempt will
+// then reconnect
+func (rc *retryableRedisConn) discard(gen uint64) {
+	if rc.lockCtx(context.Background()) != nil {
+		return
+	}
+	defer rc.unlock()
+
+	if rc.gen == gen && rc.inner != nil {
+		rc.inner.Close()
+		rc.inner = nil
+		rc.stats.disconnected()
+	}
+}
+
+// Do performs an Action, returning any error.
+func (rc *retryableRedisConn) Do(a radix.Action) error {
+	return rc.DoContext(context.Background(), a)
+}
+
+// DoContext performs an Action, returning any error. Retrying and reconnecting
+// stops once ctx is done, in which case ctx.Err() is returned.
+//
+// It's safe to call from multiple goroutines, the actions are performed one at
+// a time.
+func (rc *retryableRedisConn) DoContext(ctx context.Context, a radix.Action) error {
+	if !rc.beginCall() {
+		return ErrClosed
+	}
+	defer rc.endCall()
+
+	return intercept(rc.config().Interceptors, rc.doContext)(ctx, a)
+}
+
+func (rc *retryableRedisConn) doContext(ctx context.Context, a radix.Action) error {
+	if rc.config().FailFast && atomic.LoadInt32(&rc.bgReconnecting) == 1 {
+		return ErrNotConnected
+	}
+
+	a = captureCmd(a)
+	if rc.config().OnDoStart != nil {
+		ctx = rc.config().OnDoStart(ctx, a)
+	}
+
+	conf := rc.config()
+	slow := conf.SlowThreshold > 0 && conf.OnSlowCommand != nil
+
+	cmds := actionCmdNames(a)
+	if hasSubscribeCmd(cmds) {
+		return ErrUsePubSubAPI
+	}
+	if switchesToRESP3(cmds, a) {
+		return ErrRESP3Unsupported
+	}
+	var keys []string
+	if conf.OnDo != nil || slow {
+		keys = a.Keys()
+	}
+	var args func() [][]string
+	if conf.OnGiveUp != nil {
+		args = giveUpArgs(a)
+	}
+
+	started := time.Now()
+	var attempts int
+	var err error
+	if conf.PprofLabels {
+		pprof.Do(ctx, pprof.Labels("redis_cmd", latencyKey(cmds)), func(ctx context.Context) {
+			attempts, err = rc.do(ctx, a)
+		})
+	} else {
+		attempts, err = rc.do(ctx, a)
+	}
+	took := time.Since(started)
+	rc.stats.done(cmds, took)
+	if err != nil {
+		conf.logGiveUp(cmds, err)
+		if gaveUp(err) {
+			rc.emit(EventGaveUp, RetryInfo{Cmds: cmds, Attempt: attempts, Err: err})
+			conf.onGiveUp(cmds, args, err)
+		}
+	}
+
+	if slow && took >= conf.SlowThreshold {
+		conf.OnSlowCommand(strings.Join(cmds, ","), keys, took)
+	}
+	if conf.OnDo == nil {
+		return err
+	}
+
+	info := DoInfo{
+		Context:  ctx,
+		Cmds:     cmds,
+		Keys:     keys,
+		Duration: took,
+		Attempts: attempts,
+		Err:      err,
+	}
+	if err != nil {
+		info.Class = conf.classify(errorCause(err))
+		info.Code = ErrorCode(err)
+	}
+	conf.OnDo(info)
+
+	return err
+}
+
+// do is DoContext, it also returns the number of attempts made. Errors after a
+// retry are wrapped in an AttemptsError.
+func (rc *retryableRedisConn) do(ctx context.Context, a radix.Action) (int, error) {
+	a, opts := unwrapAction(a)
+	if !opts.isZero() {
+		return rc.doRetried(ctx, a, opts)
+	}
+
+	if rc.config().CoalesceReads || rc.config().StaleCache != nil {
+		if key, args, rcv, ok := coalesceKey(a); ok {
+			return rc.doRead(ctx, key, args, rcv)
+		}
+	}
+	return rc.doPipelined(ctx, a)
+}
+
+// doPipelined is do for an unwrapped action without options
+func (rc *retryableRedisConn) doPipelined(ctx context.Context, a radix.Action) (int, error) {
+	if rc.config().AutoPipelineWindow > 0 {
+		if cmd, ok := batchable(a); ok {
+			return rc.autoPipeline.do(ctx, cmd)
+		}
+	}
+	return rc.doRetried(ctx, a, retryOpts{})
+}
+
+// doRetried is do for an unwrapped action with the options from WrapAction,
+// without auto pipelining
+func (rc *retryableRedisConn) doRetried(ctx context.Context, a radix.Action, opts retryOpts) (int, error) {
+	var history []Attempt
+	attempts, err := rc.doAttempts(ctx, a, opts, &history)
+	if err != nil {
+		err = withAttempts(history, err, rc.config().JoinAttemptErrors)
+	}
+	return attempts, err
+}
+
+// doAttempts is doRetried, recording every failed attempt in history
+func (rc *retryableRedisConn) doAttempts(ctx context.Context, a radix.Action, opts retryOpts, history *[]Attempt) (int, error) {
+	policy, customPolicy := rc.config().actionRetryPolicy(opts)
+	retries := 0
+	// when the server started responding with LOADING or another retryable
+	// error that has a max wait
+	var waitingSince time.Time
+	var busy busyWait
+
+	// blocking commands are retried with the time they have left to block
+	var blockUntil time.Time
+	block, blocking := blockTimeout(a)
+	if blocking && block > 0 {
+		blockUntil = time.Now().Add(block)
+	}
+
+	cmds := actionCmdNames(a)
+
+	release, err := rc.queue.wait(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			release()
+			return retries, err
+		}
+
+		if retries > 0 && !blockUntil.IsZero() {
+			setBlockTimeout(a, time.Until(blockUntil))
+		}
+
+		started := time.Now()
+		gen, written, err := rc.attempt(ctx, a, opts)
+		release()
+		if retries == 0 {
+			rc.stats.firstAttempt(cmds, time.Since(started))
+		}
+		if err == nil {
+			return retries + 1, nil
+		}
+		*history = append(*history, Attempt{Err: err, Time: time.Now()})
+
+		switch rc.config().classify(err) {
+		case ErrorReconnect:
+			// error replies mean the action wasn't executed
+			replied := errors.As(err, new(resp2.Error))
+			atMostOnce := rc.config().DeliveryMode == AtMostOnce && written
+			onlyIdempotent := rc.config().RetryOnlyIdempotent && !isIdempotentAction(a)
+			if !replied && (atMostOnce || onlyIdempotent) && !isIdempotencyCmd(a) {
+				rc.discard(gen)
+				return retries + 1, ambiguousErr(a, err)
+			}
+			if opts.noReconnect {
+				rc.discard(gen)
+				rc.reconnectInBackground()
+				return retries + 1, err
+			}
+			if _, ok := policy.NextDelay(retries, err); !ok {
+				return retries + 1, &RetriesExhaustedError{Attempts: retries + 1, Err: err}
+			}
+			// connections dropped while blocking (e.g. by a load balancer
+			// closing idle connections) aren't a sign of trouble
+			blocked := blocking && time.Since(started) >= blockedMin
+			if !blocked && !rc.config().RetryBudget.take() {
+				return retries + 1, ErrBudgetExhausted
+			}
+
+			info := rc.config().newRetryInfo(ctx, a, err)
+			if err := rc.reconnectFrom(ctx, gen, info); err != nil {
+				return retries + 1, err
+			}
+
+			retries++
+			rc.stats.retried()
+			info.Attempt = retries
+			rc.config().onRetry(info)
+			rc.emit(EventRetried, info)
+		case ErrorRetryable:
+			delay, ok := policy.NextDelay(retries, err)
+			if !ok {
+				return retries + 1, &RetriesExhaustedError{Attempts: retries + 1, Err: err}
+			}
+
+			if rc.config().RetryPolicy == nil && !customPolicy {
+				if b, ok := rc.config().errorBackoff(ErrorCode(err)); ok {
+					delay = b.Delay(retries)
+				}
+			}
+
+			switch ErrorCode(err) {
+			case "LOADING":
+				if waitingSince.IsZero() {
+					waitingSince = time.Now()
+				}
+				rc.config().reportLoadingProgress()
+			case "BUSY":
+				busy.retried(rc.config())
+			}
+
+			if maxWait := rc.config().maxErrorWait(ErrorCode(err)); maxWait > 0 {
+				if waitingSince.IsZero() {
+					waitingSince = time.Now()
+				}
+
+				remaining := maxWait - time.Since(waitingSince)
+				if remaining <= 0 {
+					if isLoadingErr(err) {
+						return retries + 1, &StillLoadingError{Waited: time.Since(waitingSince), Err: err}
+					}
+					return retries + 1, &RetriesExhaustedError{Attempts: retries + 1, Err: err}
+				} else if delay > remaining {
+					delay = remaining
+				}
+			}
+			// waiting for the server to load can't succeed after the
+			// deadline, so it's given up on right away
+			if isLoadingErr(err) && pastDeadline(ctx, delay) {
+				return retries + 1, &StillLoadingError{Waited: time.Since(waitingSince), Err: err}
+			}
+			if !rc.config().RetryBudget.take() {
+				return retries + 1, ErrBudgetExhausted
+			}
+
+			(*history)[len(*history)-1].Delay = delay
+			retries++
+			rc.stats.retried()
+			info := rc.config().newRetryInfo(ctx, a, err)
+			info.Attempt = retries
+			info.Delay = delay
+			rc.config().onRetry(info)
+			rc.emit(EventRetried, info)
+			if err := rc.sleep(ctx, delay); err != nil {
+				return retries, err
+			}
+		default:
+			return retries + 1, err
+		}
+	}
+}
+
+// attempt performs the action once, connecting first if needed and allowed by
+// opts. It returns the generation of the connection it was performed on, and
+// whether a request was fully written to it.
+func (rc *retryableRedisConn) attempt(ctx context.Context, a radix.Action, opts retryOpts) (uint64, bool, error) {
+	if err := rc.lockCtx(ctx); err != nil {
+		return 0, false, err
+	}
+	defer rc.unlock()
+
+	// the initial dial or a previous reconnect loop failed, try again before
+	// using the conn
+	if rc.inner == nil {
+		if rc.config().FailFast || opts.noReconnect {
+			rc.reconnectInBackground()
+			return rc.gen, false, ErrNotConnected
+		}
+		if err := rc.reconnectLoop(ctx, rc.config().newRetryInfo(ctx, a, nil)); err != nil {
+			return rc.gen, false, err
+		}
+	}
+
+	write := !isReadOnlyAction(a)
+	if write && rc.readOnly && rc.readOnlyGen == rc.gen && rc.config().DegradeOnReadOnly {
+		return rc.gen, false, ErrReadOnlyMode
+	}
+
+	// checked before the circuit breaker, an allowed attempt has to be
+	// recorded
+	names := actionCmdNames(a)
+	if err := rc.session.checkTxn(names); err != nil {
+		return rc.gen, false, err
+	}
+
+	if !rc.config().CircuitBreaker.allow() {
+		return rc.gen, false, ErrCircuitOpen
+	}
+	rc.redialIfExpired()
+
+	stateCmds := rc.session.statefulCmds(a)
+	if p, ok := a.(*pipeline); ok {
+		p.retryablePrefixes = rc.config().RetryableErrorPrefixes
+	}
+	cc, _ := rc.inner.(*countingConn)
+	if cc != nil {
+		cc.written = false
+	}
+	err := intercept(rc.config().AttemptInterceptors, func(ctx context.Context, a radix.Action) error {
+		return rc.doInner(a)
+	})(ctx, a)
+	rc.stats.attempted(err)
+	if rc.config().DegradeOnReadOnly {
+		rc.trackReadOnly(write, err)
+	}
+	if err == nil {
+		rc.session.track(stateCmds)
+		rc.setDB(rc.session.db)
+		if w := rc.config().actionWait(opts); rc.needsWait(write, w) {
+			err = rc.wait(w)
+		}
+	} else if rc.session.multi {
+		// the server fails EXEC if queueing failed, and an EXEC that may
+		// have run must not be replayed
+		if errors.As(err, new(resp2.Error)) || hasCmd(names, "EXEC") {
+			rc.session.queueFailed = true
+		}
+	}
+	class := ErrorFatal
+	if err != nil {
+		class = rc.config().classify(err)
+	}
+
+	// a conflict in a Watch transaction is a healthy response
+	conflict := errors.Is(err, ErrWatchConflict)
+	rc.config().CircuitBreaker.record(class != ErrorFatal && !conflict, rc.config().HealthyPeriod)
+	switch {
+	case class == ErrorFatal || conflict:
+		rc.setState(StateConnected, nil)
+	case class == ErrorRetryable:
+		rc.setState(StateDegraded, err)
+	}
+
+	return rc.gen, cc != nil && cc.written, err
+}
+
+// trackReadOnly enters read-only mode after errors signaling a failover, and
+// ends it after a successful write. rc.lock has to be held.
+func (rc *retryableRedisConn) trackReadOnly(write bool, err error) {
+	switch code := ErrorCode(err); {
+	case code == "READONLY" || code == "MASTERDOWN":
+		if !rc.readOnly {
+			rc.config().logReadOnly(err)
+		}
+		rc.readOnly, rc.readOnlyGen = true, rc.gen
+	case err == nil && write:
+		rc.readOnly = false
+	}
+}
+
+// reconnectInBackground starts a reconnect loop in the background, unless one
+// is running already
+func (rc *retryableRedisConn) reconnectInBackground() {
+	if !atomic.CompareAndSwapInt32(&rc.bgReconnecting, 0, 1) {
+		return
+	}
+
+	go func() {
+		defer atomic.StoreInt32(&rc.bgReconnecting, 0)
+
+		if rc.lockCtx(context.Background()) != nil {
+			return
+		}
+		defer rc.unlock()
+
+		if rc.inner == nil {
+			rc.reconnectLoop(context.Background(), rc.config().newRetryInfo(context.Background(), nil, ErrNotConnected))
+		}
+	}()
+}
+
+// doInner performs a on inner, closing inner if it takes longer than
+// CommandTimeout (plus the time a blocking command blocks for). rc.lock has to
+// be held.
+func (rc *retryableRedisConn) doInner(a radix.Action) error {
+	if reset := rc.config().setDeadlines(rc.inner.NetConn(), a); reset != nil {
+		defer reset()
+	}
+	return rc.doInnerTimeout(a, rc.config().attemptTimeout(a))
+}
+
+// doInnerTimeout is doInner with the given timeout, 0 means no timeout
+func (rc *retryableRedisConn) doInnerTimeout(a radix.Action, timeout time.Duration) error {
+	if timeout <= 0 {
+		rc.setInFlight(rc.inner)
+		defer rc.setInFlight(nil)
+		return rc.inner.Do(a)
+	}
+
+	inner := rc.inner
+	rc.setInFlight(inner)
+	defer rc.setInFlight(nil)
+	watchdog := time.AfterFunc(timeout, func() {
+		inner.Close()
+	})
+	err := inner.Do(a)
+	if !watchdog.Stop() && err != nil {
+		return ErrCommandTimeout
+	}
+	return err
+}
+
+// maxErrorWait returns the max time to keep retrying error replies with the
+// given prefix, 0 if there's no limit
+func (conf *DialConfig) maxErrorWait(prefix string) time.Duration {
+	switch prefix {
+	case "LOADING":
+		return conf.MaxLoadingWait
+	case "MASTERDOWN":
+		return conf.MaxMasterDownWait
+	}
+
+	return 0
+}
+
+func ambiguousErr(a radix.Action, err error) error {
+	var cmd string
+	if names := actionCmdNames(a); len(names) > 0 {
+		cmd = strings.Join(names, ",")
+	}
+	return &AmbiguousResultError{Cmd: cmd, Err: err}
+}
+
+// isLoadingErr reports whether err is a LOADING error reply, sent while the
+// server is loading its dataset into memory
+func isLoadingErr(err error) bool {
+	return ErrorCode(err) == "LOADING"
+}
+
+// sleep waits for d, returning early with ctx.Err() if ctx is done first
+func sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
+
+// pastDeadline reports whether the deadline of ctx passes within d
+func pastDeadline(ctx context.Context, d time.Duration) bool {
+	deadline, ok := ctx.Deadline()
+	return ok && time.Until(deadline) < d
+}
+
+// isClosed reports whether Close was called
+func (rc *retryableRedisConn) isClosed() bool {
+	select {
+	case <-rc.closeCh:
+		return true
+	default:
+		return false
+	}
+}
+
+// sleep waits for d, returning early with ctx.Err() if ctx is done or ErrClosed
+// if the conn is closed first
+func (rc *retryableRedisConn) sleep(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return nil
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-rc.closeCh:
+		return ErrClosed
+	case <-t.C:
+		return nil
+	}
+}
+
+// Once Close() is called all future method calls on the Client will return
+// an error, Do returns ErrClosed. Retry and reconnect loops in progress are
+// stopped, though a dial in progress is waited for.
+func (rc *retryableRedisConn) Close() error {
+	if !rc.markClosed() {
+		return ErrClosed
+	}
+	return rc.closeInner()
+}
+
+// markClosed closes closeCh, reporting false if it was closed already
+func (rc *retryableRedisConn) markClosed() bool {
+	closed := false
+	rc.closeOnce.Do(func() {
+		// stops any loops in progress so the lock can be acquired
+		close(rc.closeCh)
+		closed = true
+	})
+	return closed
+}
+
+// closeInner closes the current connection once the lock is free, after
+// markClosed
+func (rc *retryableRedisConn) closeInner() error {
+	rc.lock <- struct{}{}
+	defer rc.unlock()
+
+	rc.setState(StateClosed, nil)
+	rc.stats.disconnected()
+	rc.closeEvents()
+	if rc.inner == nil {
+		return nil
+	}
+	return rc.inner.Close()
+}
+
+// getInner returns the current connection, which may be nil
+func (rc *retryableRedisConn) getInner() radix.Conn {
+	rc.lock <- struct{}{}
+	defer rc.unlock()
+
+	return rc.inner
+}
+
+// Encode and Decode go straight to the current connection without retrying,
+// they aren't synchronized with other calls, use Do instead where possible.
+// They return ErrNotConnected if there's no connection.
+func (rc *retryableRedisConn) Encode(m resp.Marshaler) error {
+	inner := rc.getInner()
+	if inner == nil {
+		return ErrNotConnected
+	}
+	return inner.Encode(m)
+}
+
+func (rc *retryableRedisConn) Decode(um resp.Unmarshaler) error {
+	inner := rc.getInner()
+	if inner == nil {
+		return ErrNotConnected
+	}
+	return inner.Decode(um)
+}
+
+// Returns the underlying network connection, as-is. Read, Write, and Close
+// should not be called on the returned Conn. It returns nil if there's no
+// connection.
+func (rc *retryableRedisConn) NetConn() net.Conn {
+	inner := rc.getInner()
+	if inner == nil {
+		return nil
+	}
+	return inner.NetConn()
+}
+
+func FlatCmd(rcv interface{}, cmd, key string, args ...interface{}) radix.CmdAction {
+	retryableCmd := flatCmdPool.Get().(*RetryableFlatCmd)
+	*retryableCmd = RetryableFlatCmd{
+		rcv:  rcv,
+		cmd:  cmd,
+		key:  key,
+		args: args,
+
+		inner: radix.FlatCmd(rcv, cmd, key, args...),
+	}
+
+	return retryableCmd
+}
+
+type RetryableFlatCmd struct {
+	rcv  interface{}
+	cmd  string
+	key  string
+	args []interface{}
+
+	inner radix.CmdAction
+
+	// wire, if set by giveUpArgs, holds what was first written for args
+	// that can only be read once
+	wire *bytes.Buffer
+}
+
+func (r *RetryableFlatCmd) getInner() radix.CmdAction {
+	if r.inner == nil {
+		r.inner = radix.FlatCmd(r.rcv, r.cmd, r.key, r.args...)
+	}
+
+	return r.inner
+}
+
+func (r *RetryableFlatCmd) Keys() []string {
+	return r.getInner().Keys()
+}
+
+func (r *RetryableFlatCmd) Run(conn radix.Conn) error {
+	if err := conn.Encode(r); err != nil {
+		return err
+	}
+
+	return conn.Decode(r)
+}
+
+// ClusterCanRetry implements radix.ClusterCanRetryAction, making radix.Cluster
+// follow MOVED and ASK redirects
+func (r *RetryableFlatCmd) ClusterCanRetry() bool {
+	return true
+}
+
+func (c *RetryableFlatCmd) MarshalRESP(w io.Writer) error {
+	// only the first attempt reads the args
+	if c.wire != nil && c.wire.Len() == 0 {
+		w = io.MultiWriter(w, c.wire)
+	}
+	return c.getInner().MarshalRESP(w)
+}
+
+func (c *RetryableFlatCmd) UnmarshalRESP(br *bufio.Reader) error {
+	err := c.getInner().UnmarshalRESP(br)
+
+	// radix puts the action back into its pool once it succeeded, so it
+	// can't be reused after that, but can be for the retry after a failure
+	if err == nil {
+		c.inner = nil
+	}
+
+	return err
+}
+
+func Cmd(rcv interface{}, cmd string, args ...string) radix.CmdAction {
+	retryableCmd := cmdPool.Get().(*RetryableCmd)
+	*retryableCmd = RetryableCmd{
+		rcv:  rcv,
+		cmd:  cmd,
+		args: args,
+
+		inner: radix.Cmd(rcv, cmd, args...),
+	}
+
+	return retryableCmd
+}
+
+type RetryableCmd struct {
+	rcv  interface{}
+	cmd  string
+	key  string
+	args []string
+
+	inner radix.CmdAction
+}
+
+func (r *RetryableCmd) getInner() radix.CmdAction {
+	if r.inner == nil {
+		r.inner = radix.Cmd(r.rcv, r.cmd, r.args...)
+	}
+
+	return r.inner
+}
+
+func (r *RetryableCmd) Keys() []string {
+	return r.getInner().Keys()
+}
+
+func (r *RetryableCmd) Run(conn radix.Conn) error {
+	if err := conn.Encode(r); err != nil {
+		return err
+	}
+	return conn.Decode(r)
+}
+
+// ClusterCanRetry implements radix.ClusterCanRetryAction, making radix.Cluster
+// follow MOVED and ASK redirects
+func (r *RetryableCmd) ClusterCanRetry() bool {
+	return true
+}
+
+func (c *RetryableCmd) MarshalRESP(w io.Writer) error {
+	return c.getInner().MarshalRESP(w)
+}
+
+func (c *RetryableCmd) UnmarshalRESP(br *bufio.Reader) error {
+	err := c.getInner().UnmarshalRESP(br)
+
+	// radix puts the action back into its pool once it succeeded, so it
+	// can't be reused after that, but can be for the retry after a failure
+	if err == nil {
+		c.inner = nil
+	}
+
+	return err
+}
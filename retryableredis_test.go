@@ -0,0 +1,203 @@
+package retryableredis_test
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+// testBackoff keeps the tests fast
+var testBackoff = retryableredis.Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+
+// callbacks records the reconnects and retries of a conn
+type callbacks struct {
+	mu         sync.Mutex
+	reconnects []error
+	retries    []retryableredis.RetryInfo
+}
+
+func (cb *callbacks) onReconnect(err error) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.reconnects = append(cb.reconnects, err)
+}
+
+func (cb *callbacks) onRetryInfo(info retryableredis.RetryInfo) {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	cb.retries = append(cb.retries, info)
+}
+
+// check fails the test unless there was a reconnect caused by an error, and a
+// retry of the action after it
+func (cb *callbacks) check(t *testing.T) {
+	t.Helper()
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+
+	var reconnected bool
+	for _, err := range cb.reconnects {
+		reconnected = reconnected || err != nil
+	}
+	if !reconnected {
+		t.Errorf("OnReconnect wasn't called for a broken conn, got %v", cb.reconnects)
+	}
+	if len(cb.retries) < 1 {
+		t.Error("OnRetryInfo wasn't called")
+	}
+}
+
+func dialProxy(t *testing.T, addr string, cb *callbacks, conf retryableredis.DialConfig) retryableredis.Conn {
+	t.Helper()
+	conf.Network = "tcp"
+	conf.Addr = addr
+	conf.RetryBackoff = testBackoff
+	conf.ReconnectBackoff = testBackoff
+	conf.OnReconnect = cb.onReconnect
+	conf.OnRetryInfo = cb.onRetryInfo
+
+	conn, err := retryableredis.Dial(&conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestDoSurvivesReset(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.Set("k", "v")
+	proxy := retryableredistest.NewProxy(m.Addr())
+	defer proxy.Close()
+
+	var cb callbacks
+	conn := dialProxy(t, proxy.Addr(), &cb, retryableredis.DialConfig{})
+
+	// the GET reaches the server, the conn is reset before the reply
+	proxy.BreakOn("GET", retryableredistest.FaultReset)
+	var v string
+	if err := conn.Do(retryableredis.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+	cb.check(t)
+}
+
+func TestDoSurvivesHalfOpen(t *testing.T) {
+	m := miniredis.RunT(t)
+	m.Set("k", "v")
+	proxy := retryableredistest.NewProxy(m.Addr())
+	defer proxy.Close()
+
+	var cb callbacks
+	conn := dialProxy(t, proxy.Addr(), &cb, retryableredis.DialConfig{
+		CommandTimeout: 50 * time.Millisecond,
+	})
+	conn.UpdateConfig(func(conf *retryableredis.DialConfig) {
+		onReconnect := conf.OnReconnect
+		conf.OnReconnect = func(err error) {
+			// the proxy forwards again once the half-open conn is given up
+			proxy.Resume()
+			onReconnect(err)
+		}
+	})
+
+	// the GET reaches the server but nothing comes back, like a dead peer
+	// that never closes the connection
+	proxy.BreakOn("GET", retryableredistest.FaultStall)
+	var v string
+	if err := conn.Do(retryableredis.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+	cb.check(t)
+}
+
+func TestReconnectOnEOF(t *testing.T) {
+	s := newFlakyServer(t)
+	s.Redis.Set("k", "v")
+
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{})
+
+	// the server closes the connection, the next read gets an EOF
+	s.Proxy.Break(retryableredistest.FaultDrop)
+	var v string
+	if err := conn.Do(retryableredis.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v" {
+		t.Fatalf("got %q, want %q", v, "v")
+	}
+	cb.check(t)
+	if got := conn.Stats().Reconnects; got != 1 {
+		t.Fatalf("reconnected %d times, want 1", got)
+	}
+}
+
+func TestConcurrentDoReconnect(t *testing.T) {
+	s := newFlakyServer(t)
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{})
+
+	// the conn is reset a few times while the goroutines use it
+	stop := make(chan struct{})
+	breaks := make(chan struct{})
+	go func() {
+		defer close(breaks)
+		for i := 0; i < 5; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(5 * time.Millisecond):
+			}
+			s.Proxy.Break(retryableredistest.FaultReset)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			key := "k" + strconv.Itoa(g)
+			for i := 0; i < 50; i++ {
+				want := strconv.Itoa(i)
+				var v string
+				if err := conn.Do(retryableredis.Cmd(nil, "SET", key, want)); err != nil {
+					errs <- err
+					return
+				}
+				if err := conn.Do(retryableredis.Cmd(&v, "GET", key)); err != nil {
+					errs <- err
+					return
+				}
+				// a reply read for another goroutine's cmd would show here
+				if v != want {
+					errs <- fmt.Errorf("GET %s returned %q, want %q", key, v, want)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	close(stop)
+	<-breaks
+
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	cb.check(t)
+}
@@ -0,0 +1,50 @@
+// Package retryableredisexpvar publishes the stats of retryableredis conns
+// with expvar, so they show up in /debug/vars. It's a separate package as
+// importing expvar registers that handler.
+package retryableredisexpvar
+
+import (
+	"expvar"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+)
+
+// Publish publishes the stats of c under name, e.g:
+//
+//	"redis": {"retries": 3, "reconnects": 1, "state": "connected", "last_error": "EOF", ...}
+//
+// Like expvar.Publish it panics if name is already in use.
+func Publish(name string, c retryableredis.Conn) {
+	expvar.Publish(name, Func(c))
+}
+
+// Func returns an expvar.Func reporting the stats of c, for publishing them
+// within a map of your own
+func Func(c retryableredis.Conn) expvar.Func {
+	return func() interface{} {
+		st := c.Stats()
+
+		vars := map[string]interface{}{
+			"retries":          st.Retries,
+			"reconnects":       st.Reconnects,
+			"state":            c.State().String(),
+			"last_error":       "",
+			"last_error_at":    "",
+			"last_success":     "",
+			"uptime_seconds":   st.Uptime.Seconds(),
+			"bytes_read":       st.BytesRead,
+			"bytes_written":    st.BytesWritten,
+			"commands_sent":    st.CommandsSent,
+			"replies_received": st.RepliesReceived,
+		}
+		if st.LastError != nil {
+			vars["last_error"] = st.LastError.Error()
+			vars["last_error_at"] = st.LastErrorAt.Format(time.RFC3339)
+		}
+		if !st.LastSuccess.IsZero() {
+			vars["last_success"] = st.LastSuccess.Format(time.RFC3339)
+		}
+		return vars
+	}
+}
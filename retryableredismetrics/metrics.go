@@ -0,0 +1,176 @@
+// Package retryableredismetrics provides a prometheus.Collector for the
+// retries, reconnects, errors and latencies of retryableredis conns, and the
+// statistics of pools
+package retryableredismetrics
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// Collector is a prometheus.Collector, use Instrument to have it track a conn
+type Collector struct {
+	retries      *prometheus.CounterVec
+	reconnects   prometheus.Counter
+	errors       *prometheus.CounterVec
+	cmdLatency   *prometheus.HistogramVec
+	dialDuration prometheus.Histogram
+
+	poolConns        *prometheus.Desc
+	poolWaits        *prometheus.Desc
+	poolWaitDuration *prometheus.Desc
+	poolCreated      *prometheus.Desc
+	poolClosed       *prometheus.Desc
+
+	mu    sync.Mutex
+	pools map[string]*retryableredis.Pool
+}
+
+var _ prometheus.Collector = (*Collector)(nil)
+
+// NewCollector returns a new Collector with its metrics in the given namespace
+func NewCollector(namespace string) *Collector {
+	return &Collector{
+		retries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "redis",
+			Name:      "retries_total",
+			Help:      "Number of retried actions, by the class of the error causing the retry",
+		}, []string{"class"}),
+		reconnects: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "redis",
+			Name:      "reconnects_total",
+			Help:      "Number of reconnect attempts",
+		}),
+		errors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Subsystem: "redis",
+			Name:      "errors_total",
+			Help:      "Number of errors returned from Do, by class",
+		}, []string{"class"}),
+		cmdLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "redis",
+			Name:      "command_duration_seconds",
+			Help:      "Time spent in Do including retries, by command",
+			Buckets:   prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}, []string{"cmd"}),
+		dialDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Subsystem: "redis",
+			Name:      "dial_duration_seconds",
+			Help:      "Time spent dialing new connections",
+			Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+		}),
+
+		poolConns: prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis", "pool_conns"),
+			"Number of open conns in the pool, by state (idle or in_use)", []string{"pool", "state"}, nil),
+		poolWaits: prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis", "pool_waits_total"),
+			"Number of times a conn had to be waited for", []string{"pool"}, nil),
+		poolWaitDuration: prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis", "pool_wait_seconds_total"),
+			"Total time spent waiting for a conn", []string{"pool"}, nil),
+		poolCreated: prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis", "pool_conns_created_total"),
+			"Number of conns opened by the pool", []string{"pool"}, nil),
+		poolClosed: prometheus.NewDesc(prometheus.BuildFQName(namespace, "redis", "pool_conns_closed_total"),
+			"Number of conns closed by the pool", []string{"pool"}, nil),
+
+		pools: map[string]*retryableredis.Pool{},
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	c.retries.Describe(ch)
+	c.reconnects.Describe(ch)
+	c.errors.Describe(ch)
+	c.cmdLatency.Describe(ch)
+	c.dialDuration.Describe(ch)
+	ch <- c.poolConns
+	ch <- c.poolWaits
+	ch <- c.poolWaitDuration
+	ch <- c.poolCreated
+	ch <- c.poolClosed
+}
+
+// Collect implements prometheus.Collector
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	c.retries.Collect(ch)
+	c.reconnects.Collect(ch)
+	c.errors.Collect(ch)
+	c.cmdLatency.Collect(ch)
+	c.dialDuration.Collect(ch)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for name, p := range c.pools {
+		stats := p.Stats()
+		ch <- prometheus.MustNewConstMetric(c.poolConns, prometheus.GaugeValue, float64(stats.Idle), name, "idle")
+		ch <- prometheus.MustNewConstMetric(c.poolConns, prometheus.GaugeValue, float64(stats.InUse), name, "in_use")
+		ch <- prometheus.MustNewConstMetric(c.poolWaits, prometheus.CounterValue, float64(stats.Waits), name)
+		ch <- prometheus.MustNewConstMetric(c.poolWaitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(c.poolCreated, prometheus.CounterValue, float64(stats.Created), name)
+		ch <- prometheus.MustNewConstMetric(c.poolClosed, prometheus.CounterValue, float64(stats.Closed), name)
+	}
+}
+
+// AddPool adds the Stats of p to the collected metrics, labeled with name. The
+// conns of the pool are instrumented by passing its ConnConfig to Instrument.
+func (c *Collector) AddPool(name string, p *retryableredis.Pool) {
+	c.mu.Lock()
+	c.pools[name] = p
+	c.mu.Unlock()
+}
+
+// Instrument sets up the callbacks in conf to update the collector's
+// metrics, callbacks already set in conf are still called. It has to be called
+// before dialing.
+func (c *Collector) Instrument(conf *retryableredis.DialConfig) {
+	onRetryInfo := conf.OnRetryInfo
+	conf.OnRetryInfo = func(info retryableredis.RetryInfo) {
+		c.retries.WithLabelValues(info.Class.String()).Inc()
+		if onRetryInfo != nil {
+			onRetryInfo(info)
+		}
+	}
+
+	onReconnectInfo := conf.OnReconnectInfo
+	conf.OnReconnectInfo = func(info retryableredis.RetryInfo) {
+		c.reconnects.Inc()
+		if onReconnectInfo != nil {
+			onReconnectInfo(info)
+		}
+	}
+
+	onDial := conf.OnDial
+	conf.OnDial = func(info retryableredis.DialInfo) {
+		c.dialDuration.Observe(info.Duration.Seconds())
+		if onDial != nil {
+			onDial(info)
+		}
+	}
+
+	onDo := conf.OnDo
+	conf.OnDo = func(info retryableredis.DoInfo) {
+		c.cmdLatency.WithLabelValues(cmdLabel(info.Cmds)).Observe(info.Duration.Seconds())
+		if info.Err != nil {
+			c.errors.WithLabelValues(info.Class.String()).Inc()
+		}
+		if onDo != nil {
+			onDo(info)
+		}
+	}
+}
+
+func cmdLabel(cmds []string) string {
+	switch len(cmds) {
+	case 0:
+		return "unknown"
+	case 1:
+		return strings.ToUpper(cmds[0])
+	}
+	return "pipeline"
+}
@@ -0,0 +1,92 @@
+// Package retryableredisotel adds OpenTelemetry tracing to retryableredis
+// conns, creating a span for every Do call with the retries and reconnects it
+// went through recorded as span events
+package retryableredisotel
+
+import (
+	"context"
+	"strings"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/mediocregopher/radix/v3"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Instrument sets up the callbacks in conf to trace every Do call using
+// tracer, callbacks already set in conf are still called. It has to be called
+// before dialing.
+func Instrument(conf *retryableredis.DialConfig, tracer trace.Tracer) {
+	onDoStart := conf.OnDoStart
+	conf.OnDoStart = func(ctx context.Context, a radix.Action) context.Context {
+		if onDoStart != nil {
+			ctx = onDoStart(ctx, a)
+		}
+
+		ctx, _ = tracer.Start(ctx, "redis", trace.WithSpanKind(trace.SpanKindClient))
+		return ctx
+	}
+
+	onRetryInfo := conf.OnRetryInfo
+	conf.OnRetryInfo = func(info retryableredis.RetryInfo) {
+		trace.SpanFromContext(info.Context).AddEvent("retry", trace.WithAttributes(
+			attribute.Int("redis.attempt", info.Attempt),
+			attribute.String("redis.retry_reason", info.Class.String()),
+			attribute.String("redis.error", errString(info.Err)),
+			attribute.Int64("redis.delay_ms", info.Delay.Milliseconds()),
+		))
+		if onRetryInfo != nil {
+			onRetryInfo(info)
+		}
+	}
+
+	onReconnectInfo := conf.OnReconnectInfo
+	conf.OnReconnectInfo = func(info retryableredis.RetryInfo) {
+		trace.SpanFromContext(info.Context).AddEvent("reconnect", trace.WithAttributes(
+			attribute.Int("redis.attempt", info.Attempt),
+			attribute.String("redis.error", errString(info.Err)),
+		))
+		if onReconnectInfo != nil {
+			onReconnectInfo(info)
+		}
+	}
+
+	onDo := conf.OnDo
+	conf.OnDo = func(info retryableredis.DoInfo) {
+		span := trace.SpanFromContext(info.Context)
+		span.SetName(spanName(info.Cmds))
+		span.SetAttributes(
+			attribute.String("db.system", "redis"),
+			attribute.String("db.operation", strings.Join(info.Cmds, " ")),
+			attribute.StringSlice("redis.keys", info.Keys),
+			attribute.Int("redis.attempts", info.Attempts),
+		)
+		if info.Err != nil {
+			span.RecordError(info.Err)
+			span.SetStatus(codes.Error, info.Err.Error())
+		}
+		span.End()
+
+		if onDo != nil {
+			onDo(info)
+		}
+	}
+}
+
+func spanName(cmds []string) string {
+	switch len(cmds) {
+	case 0:
+		return "redis"
+	case 1:
+		return "redis " + strings.ToUpper(cmds[0])
+	}
+	return "redis pipeline"
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,46 @@
+package retryableredistest
+
+import (
+	"github.com/alicebob/miniredis/v2"
+)
+
+// FlakyServer is an in-memory redis (miniredis) behind a Proxy, to test
+// reconnecting and LOADING handling deterministically against real commands
+type FlakyServer struct {
+	// Redis is the miniredis server, e.g to set up or check data
+	Redis *miniredis.Miniredis
+
+	// Proxy is what clients connect to, e.g to break connections with
+	Proxy *Proxy
+}
+
+// NewFlakyServer starts a FlakyServer on localhost
+func NewFlakyServer() (*FlakyServer, error) {
+	m, err := miniredis.Run()
+	if err != nil {
+		return nil, err
+	}
+
+	return &FlakyServer{
+		Redis: m,
+		Proxy: NewProxy(m.Addr()),
+	}, nil
+}
+
+// Addr returns the address to connect to
+func (s *FlakyServer) Addr() string {
+	return s.Proxy.Addr()
+}
+
+// Restart drops all the connections like a restarting redis, the next loading
+// commands then get a LOADING error reply. The data is kept.
+func (s *FlakyServer) Restart(loading int) {
+	s.Proxy.Break(FaultDrop)
+	s.Proxy.Respond(Loading(), loading)
+}
+
+// Close stops the server
+func (s *FlakyServer) Close() {
+	s.Proxy.Close()
+	s.Redis.Close()
+}
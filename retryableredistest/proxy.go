@@ -0,0 +1,269 @@
+package retryableredistest
+
+import (
+	"bufio"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// Fault is a way for Proxy to break a connection
+type Fault int
+
+const (
+	// FaultDrop closes the connection
+	FaultDrop Fault = iota + 1
+
+	// FaultReset closes the connection with a TCP reset, like a crashed
+	// server or a firewall would
+	FaultReset
+
+	// FaultStall stops forwarding data until Resume is called, like a
+	// half-open connection
+	FaultStall
+)
+
+// Proxy forwards connections to a redis server, and breaks them on demand or
+// when a given command is sent through it
+type Proxy struct {
+	l      net.Listener
+	target string
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	stalled bool
+	faults  map[string]Fault
+	conns   map[*proxyConn]bool
+
+	// respond is the reply to give to the next respondN commands instead
+	// of forwarding them
+	respond  Reply
+	respondN int
+
+	wg sync.WaitGroup
+}
+
+type proxyConn struct {
+	client, server net.Conn
+
+	// pending holds an entry for every command forwarded whose reply is
+	// still to come, the Reply to replace it with or nil
+	mu      sync.Mutex
+	pending []*Reply
+}
+
+// NewProxy starts a Proxy listening on localhost and forwarding to target
+func NewProxy(target string) *Proxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	p := &Proxy{
+		l:      l,
+		target: target,
+		faults: map[string]Fault{},
+		conns:  map[*proxyConn]bool{},
+	}
+	p.cond = sync.NewCond(&p.mu)
+	p.wg.Add(1)
+	go p.accept()
+	return p
+}
+
+// Addr returns the address the proxy listens on
+func (p *Proxy) Addr() string {
+	return p.l.Addr().String()
+}
+
+// Break applies f to all the current connections
+func (p *Proxy) Break(f Fault) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if f == FaultStall {
+		p.stalled = true
+		return
+	}
+	for pc := range p.conns {
+		pc.close(f == FaultReset)
+	}
+}
+
+// Resume makes stalled connections forward data again
+func (p *Proxy) Resume() {
+	p.mu.Lock()
+	p.stalled = false
+	p.mu.Unlock()
+	p.cond.Broadcast()
+}
+
+// BreakOn applies f to all the connections once cmd is next sent through the
+// proxy. The cmd is forwarded to the server first, so it may run without its
+// reply ever getting back.
+func (p *Proxy) BreakOn(cmd string, f Fault) {
+	p.mu.Lock()
+	p.faults[strings.ToUpper(cmd)] = f
+	p.mu.Unlock()
+}
+
+// Respond makes the proxy reply to the next n commands with r itself, e.g
+// Loading() to act like a restarting redis. A PING is forwarded in place of
+// them to keep the replies in order, so commands that get no reply (e.g after
+// CLIENT REPLY OFF) mustn't be sent meanwhile.
+func (p *Proxy) Respond(r Reply, n int) {
+	p.mu.Lock()
+	p.respond, p.respondN = r, n
+	p.mu.Unlock()
+}
+
+// Close stops the proxy and closes all the connections through it
+func (p *Proxy) Close() error {
+	err := p.l.Close()
+	p.mu.Lock()
+	p.stalled = false
+	for pc := range p.conns {
+		pc.close(false)
+	}
+	p.mu.Unlock()
+	p.cond.Broadcast()
+	p.wg.Wait()
+	return err
+}
+
+func (pc *proxyConn) close(reset bool) {
+	if tcpConn, ok := pc.client.(*net.TCPConn); ok && reset {
+		tcpConn.SetLinger(0)
+	}
+	pc.client.Close()
+	pc.server.Close()
+}
+
+func (p *Proxy) accept() {
+	defer p.wg.Done()
+	for {
+		client, err := p.l.Accept()
+		if err != nil {
+			return
+		}
+
+		server, err := net.Dial("tcp", p.target)
+		if err != nil {
+			client.Close()
+			continue
+		}
+
+		pc := &proxyConn{client: client, server: server}
+		p.mu.Lock()
+		p.conns[pc] = true
+		p.mu.Unlock()
+
+		p.wg.Add(2)
+		go p.forwardCmds(pc)
+		go p.forwardReplies(pc)
+	}
+}
+
+// wait blocks while the proxy is stalled
+func (p *Proxy) wait() {
+	p.mu.Lock()
+	for p.stalled {
+		p.cond.Wait()
+	}
+	p.mu.Unlock()
+}
+
+// forwardCmds forwards the commands from the client one by one, applying the
+// faults set with BreakOn
+func (p *Proxy) forwardCmds(pc *proxyConn) {
+	defer p.wg.Done()
+	defer p.remove(pc)
+
+	br := bufio.NewReader(pc.client)
+	for {
+		var raw resp2.RawMessage
+		if err := raw.UnmarshalRESP(br); err != nil {
+			return
+		}
+
+		var args []string
+		if err := raw.UnmarshalInto(resp2.Any{I: &args}); err != nil || len(args) < 1 {
+			args = []string{""}
+		}
+		name := strings.ToUpper(args[0])
+
+		p.mu.Lock()
+		var sub *Reply
+		if p.respondN > 0 {
+			p.respondN--
+			r := p.respond
+			sub, raw = &r, resp2.RawMessage(pingCmd)
+		}
+		f, ok := p.faults[name]
+		delete(p.faults, name)
+		p.mu.Unlock()
+
+		p.wait()
+		pc.mu.Lock()
+		pc.pending = append(pc.pending, sub)
+		pc.mu.Unlock()
+		if _, err := pc.server.Write(raw); err != nil {
+			return
+		}
+
+		if ok {
+			p.Break(f)
+		}
+	}
+}
+
+// pingCmd is forwarded in place of the commands the proxy responds to itself
+const pingCmd = "*1\r\n$4\r\nPING\r\n"
+
+// forwardReplies forwards the replies from the server one by one, replacing
+// the ones for commands the proxy responds to itself
+func (p *Proxy) forwardReplies(pc *proxyConn) {
+	defer p.wg.Done()
+	defer p.remove(pc)
+
+	br := bufio.NewReader(pc.server)
+	for {
+		var raw resp2.RawMessage
+		if err := raw.UnmarshalRESP(br); err != nil {
+			return
+		}
+
+		var sub *Reply
+		pc.mu.Lock()
+		if len(pc.pending) > 0 {
+			sub = pc.pending[0]
+			pc.pending = pc.pending[1:]
+		}
+		pc.mu.Unlock()
+
+		if sub != nil {
+			if sub.Delay > 0 {
+				time.Sleep(sub.Delay)
+			}
+			if sub.Drop {
+				return
+			}
+			raw = resp2.RawMessage(sub.Raw)
+		}
+
+		p.wait()
+		if _, err := pc.client.Write(raw); err != nil {
+			return
+		}
+	}
+}
+
+func (p *Proxy) remove(pc *proxyConn) {
+	p.mu.Lock()
+	delete(p.conns, pc)
+	p.mu.Unlock()
+	pc.close(false)
+}
@@ -0,0 +1,265 @@
+// Package retryableredistest helps testing retry handling, with a Proxy that
+// breaks the connections to a redis server, a FlakyServer running miniredis
+// behind one, and a fake Server with scripted replies:
+//
+//	srv := retryableredistest.NewServer(nil)
+//	defer srv.Close()
+//	srv.Push(retryableredistest.Loading(), retryableredistest.Drop())
+//
+//	conn, err := retryableredis.Dial(&retryableredis.DialConfig{
+//		Network: "tcp",
+//		Addr:    srv.Addr(),
+//		Dialer:  srv.Dial,
+//	})
+package retryableredistest
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// Reply is the scripted response to a command
+type Reply struct {
+	// Raw is the RESP written as the reply, e.g "+OK\r\n"
+	Raw string
+
+	// Delay is waited before replying, e.g to trigger CommandTimeout
+	Delay time.Duration
+
+	// Drop closes the connection instead of replying
+	Drop bool
+}
+
+// After returns r delayed by d
+func (r Reply) After(d time.Duration) Reply {
+	r.Delay = d
+	return r
+}
+
+// Raw returns a Reply writing the given RESP
+func Raw(resp string) Reply {
+	return Reply{Raw: resp}
+}
+
+// OK returns a +OK Reply
+func OK() Reply {
+	return Raw("+OK\r\n")
+}
+
+// Value returns a Reply with v marshaled like radix does for command
+// arguments and receivers, e.g strings as bulk strings. nil is a nil reply.
+func Value(v interface{}) Reply {
+	if v == nil {
+		return Raw("$-1\r\n")
+	}
+
+	buf := new(bytes.Buffer)
+	if err := (resp2.Any{I: v, MarshalBulkString: true}).MarshalRESP(buf); err != nil {
+		panic(err)
+	}
+	return Raw(buf.String())
+}
+
+// Error returns a Reply with the error reply msg, e.g "BUSY script running"
+func Error(msg string) Reply {
+	return Raw("-" + msg + "\r\n")
+}
+
+// Loading returns a LOADING error Reply, sent by redis while loading its
+// dataset
+func Loading() Reply {
+	return Error("LOADING Redis is loading the dataset in memory")
+}
+
+// Drop returns a Reply closing the connection
+func Drop() Reply {
+	return Reply{Drop: true}
+}
+
+// Server is a fake redis server, it replies to commands with the replies
+// queued with Push and after those with its handler
+type Server struct {
+	l       net.Listener
+	handler func(args []string) Reply
+
+	mu        sync.Mutex
+	script    []Reply
+	cmds      [][]string
+	conns     map[net.Conn]bool
+	failDials int
+	dials     int
+
+	wg sync.WaitGroup
+}
+
+// NewServer starts a Server listening on localhost. handler is called for the
+// commands without a queued reply, if it's nil those get +OK, or PONG for PING.
+func NewServer(handler func(args []string) Reply) *Server {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		l:       l,
+		handler: handler,
+		conns:   map[net.Conn]bool{},
+	}
+	s.wg.Add(1)
+	go s.accept()
+	return s
+}
+
+// Addr returns the address the server listens on
+func (s *Server) Addr() string {
+	return s.l.Addr().String()
+}
+
+// Dial connects to the server, it has the signature of
+// retryableredis.DialConfig.Dialer. Dials fail as set with FailDials.
+func (s *Server) Dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	s.mu.Lock()
+	s.dials++
+	fail := s.failDials > 0
+	if fail {
+		s.failDials--
+	}
+	s.mu.Unlock()
+
+	if fail {
+		return nil, &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}
+	}
+
+	var d net.Dialer
+	return d.DialContext(ctx, "tcp", s.Addr())
+}
+
+// Conn returns a radix.Conn connected to the server
+func (s *Server) Conn() (radix.Conn, error) {
+	netConn, err := s.Dial(context.Background(), "tcp", s.Addr())
+	if err != nil {
+		return nil, err
+	}
+	return radix.NewConn(netConn), nil
+}
+
+// Push queues replies for the next commands, they're used before the handler
+func (s *Server) Push(replies ...Reply) {
+	s.mu.Lock()
+	s.script = append(s.script, replies...)
+	s.mu.Unlock()
+}
+
+// FailDials makes the next n calls of Dial fail with a net error
+func (s *Server) FailDials(n int) {
+	s.mu.Lock()
+	s.failDials = n
+	s.mu.Unlock()
+}
+
+// Dials returns the number of times Dial was called
+func (s *Server) Dials() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dials
+}
+
+// Commands returns the commands received so far, oldest first
+func (s *Server) Commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.cmds...)
+}
+
+// DropConns closes all the connections to the server
+func (s *Server) DropConns() {
+	s.mu.Lock()
+	for conn := range s.conns {
+		conn.Close()
+	}
+	s.mu.Unlock()
+}
+
+// Close stops the server and closes all the connections to it
+func (s *Server) Close() error {
+	err := s.l.Close()
+	s.DropConns()
+	s.wg.Wait()
+	return err
+}
+
+func (s *Server) accept() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.l.Accept()
+		if err != nil {
+			return
+		}
+
+		s.mu.Lock()
+		s.conns[conn] = true
+		s.mu.Unlock()
+
+		s.wg.Add(1)
+		go s.serve(conn)
+	}
+}
+
+func (s *Server) serve(conn net.Conn) {
+	defer s.wg.Done()
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, conn)
+		s.mu.Unlock()
+		conn.Close()
+	}()
+
+	br := bufio.NewReader(conn)
+	for {
+		var args []string
+		if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err != nil || len(args) < 1 {
+			return
+		}
+
+		reply := s.reply(args)
+		if reply.Delay > 0 {
+			time.Sleep(reply.Delay)
+		}
+		if reply.Drop {
+			return
+		}
+		if _, err := conn.Write([]byte(reply.Raw)); err != nil {
+			return
+		}
+	}
+}
+
+// reply returns the reply to the command args
+func (s *Server) reply(args []string) Reply {
+	s.mu.Lock()
+	s.cmds = append(s.cmds, args)
+	if len(s.script) > 0 {
+		reply := s.script[0]
+		s.script = s.script[1:]
+		s.mu.Unlock()
+		return reply
+	}
+	s.mu.Unlock()
+
+	if s.handler != nil {
+		return s.handler(args)
+	}
+	if strings.ToUpper(args[0]) == "PING" {
+		return Raw("+PONG\r\n")
+	}
+	return OK()
+}
@@ -0,0 +1,322 @@
+// Package retryableredisv4 adds the retrying of retryableredis to radix v4,
+// whose Conns and Clients take a context in Do. Actions are retried as a
+// whole, except for radix.Pipeline which radix doesn't allow to be performed
+// more than once.
+package retryableredisv4
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"sync"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+	"github.com/mediocregopher/radix/v4"
+	"github.com/mediocregopher/radix/v4/resp"
+	"github.com/mediocregopher/radix/v4/resp/resp3"
+)
+
+// DialConfig configures a Conn made with Dial
+type DialConfig struct {
+	Network string
+	Addr    string
+
+	// Dialer is used for the initial connect and reconnects. Setting its
+	// Protocol to "3" negotiates RESP3 with HELLO, again on every reconnect.
+	Dialer radix.Dialer
+
+	// OnPush, if set, is called with the out-of-band push messages of RESP3
+	// (e.g the invalidations of client side caching) read while waiting for
+	// a reply. They're skipped either way, so replies stay in sync.
+	OnPush func(msg []interface{})
+
+	retryableredis.RetryConfig
+}
+
+// retryConn is a radix.Conn made with Dial
+type retryConn struct {
+	conf DialConfig
+
+	mu     sync.Mutex
+	inner  radix.Conn
+	closed bool
+}
+
+var _ radix.Conn = (*retryConn)(nil)
+
+// Dial returns a radix.Conn that performs actions with retries, reconnecting
+// when the connection breaks
+func Dial(ctx context.Context, conf *DialConfig) (radix.Conn, error) {
+	rc := &retryConn{conf: *conf}
+	if _, err := rc.getInner(ctx); err != nil {
+		return nil, err
+	}
+	return rc, nil
+}
+
+// getInner returns the current connection, dialing a new one if there's none
+func (rc *retryConn) getInner(ctx context.Context) (radix.Conn, error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return nil, retryableredis.ErrClosed
+	}
+	if rc.inner == nil {
+		inner, err := rc.conf.Dialer.Dial(ctx, rc.conf.Network, rc.conf.Addr)
+		if err != nil {
+			return nil, err
+		}
+		rc.inner = &pushConn{Conn: inner, onPush: rc.conf.OnPush}
+	}
+	return rc.inner, nil
+}
+
+// pushConn skips the push messages read before a reply
+type pushConn struct {
+	radix.Conn
+	onPush func(msg []interface{})
+}
+
+// Do performs a through the EncodeDecode of pc
+func (pc *pushConn) Do(ctx context.Context, a radix.Action) error {
+	return a.Perform(ctx, pc)
+}
+
+func (pc *pushConn) EncodeDecode(ctx context.Context, m, u interface{}) error {
+	if u != nil {
+		u = skipPushes{u: u, onPush: pc.onPush}
+	}
+	return pc.Conn.EncodeDecode(ctx, m, u)
+}
+
+// skipPushes unmarshals a reply into u, after passing any push messages that
+// precede it to onPush
+type skipPushes struct {
+	u      interface{}
+	onPush func(msg []interface{})
+}
+
+func (s skipPushes) UnmarshalRESP(br resp.BufferedReader, o *resp.Opts) error {
+	for {
+		push, err := resp3.NextMessageIs(br, resp3.PushHeaderPrefix)
+		if err != nil {
+			return err
+		} else if !push {
+			break
+		}
+
+		var msg []interface{}
+		if err := resp3.Unmarshal(br, &msg, o); err != nil {
+			return err
+		}
+		if s.onPush != nil {
+			s.onPush(msg)
+		}
+	}
+	return resp3.Unmarshal(br, s.u, o)
+}
+
+// discard closes inner if it's still the current connection, a new one is
+// dialed by the next attempt
+func (rc *retryConn) discard(inner radix.Conn) {
+	rc.mu.Lock()
+	if rc.inner == inner {
+		rc.inner = nil
+	}
+	rc.mu.Unlock()
+	inner.Close()
+}
+
+// Do implements radix.Client, ctx is passed on to every attempt and stops the
+// retrying once done
+func (rc *retryConn) Do(ctx context.Context, a radix.Action) error {
+	return retry(ctx, &rc.conf.RetryConfig, a, func(ctx context.Context) error {
+		inner, err := rc.getInner(ctx)
+		if err != nil {
+			return err
+		}
+
+		err = inner.Do(ctx, a)
+		if err != nil && brokeConn(err) {
+			rc.discard(inner)
+		}
+		return err
+	})
+}
+
+// EncodeDecode implements radix.Conn, it's passed on to the current connection
+// without retrying
+func (rc *retryConn) EncodeDecode(ctx context.Context, m, u interface{}) error {
+	rc.mu.Lock()
+	inner, closed := rc.inner, rc.closed
+	rc.mu.Unlock()
+
+	if closed {
+		return retryableredis.ErrClosed
+	} else if inner == nil {
+		return retryableredis.ErrNotConnected
+	}
+
+	err := inner.EncodeDecode(ctx, m, u)
+	if err != nil && brokeConn(err) {
+		rc.discard(inner)
+	}
+	return err
+}
+
+// Addr implements radix.Client
+func (rc *retryConn) Addr() net.Addr {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.inner != nil {
+		return rc.inner.Addr()
+	}
+	addr, _ := net.ResolveTCPAddr(rc.conf.Network, rc.conf.Addr)
+	return addr
+}
+
+// Close implements radix.Client
+func (rc *retryConn) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if rc.closed {
+		return retryableredis.ErrClosed
+	}
+	rc.closed = true
+
+	if rc.inner == nil {
+		return nil
+	}
+	err := rc.inner.Close()
+	rc.inner = nil
+	return err
+}
+
+// brokeConn reports whether the connection can't be used anymore after err
+func brokeConn(err error) bool {
+	return !isReplyErr(err) && !errors.As(err, new(resp.ErrConnUsable))
+}
+
+// retryClient is a radix.Client wrapped by WrapClient
+type retryClient struct {
+	radix.Client
+	conf retryableredis.RetryConfig
+}
+
+var _ radix.Client = (*retryClient)(nil)
+
+// WrapClient returns a radix.Client that retries the actions performed on c,
+// e.g a radix.Pool or radix.Cluster, which replace broken conns themselves
+func WrapClient(c radix.Client, conf *retryableredis.RetryConfig) radix.Client {
+	return &retryClient{Client: c, conf: *conf}
+}
+
+// Do implements radix.Client, ctx is passed on to the wrapped client and stops
+// the retrying once done
+func (rc *retryClient) Do(ctx context.Context, a radix.Action) error {
+	return retry(ctx, &rc.conf, a, func(ctx context.Context) error {
+		return rc.Client.Do(ctx, a)
+	})
+}
+
+// retry performs a with fn using retryableredis.RetryFunc, the error replies
+// of radix v4 are made recognizable to it as such
+func retry(ctx context.Context, conf *retryableredis.RetryConfig, a radix.Action, fn func(context.Context) error) error {
+	if _, ok := a.(*radix.Pipeline); ok {
+		return fn(ctx)
+	}
+
+	err := retryableredis.RetryFunc(ctx, conf, cmdNames(a), a.Properties().Keys, func(ctx context.Context) error {
+		if err := fn(ctx); err != nil {
+			if isReplyErr(err) {
+				return replyError{err: err}
+			}
+			return err
+		}
+		return nil
+	})
+
+	if re, ok := err.(replyError); ok {
+		return re.err
+	}
+	return err
+}
+
+// ErrorCode is retryableredis.ErrorCode for the error replies of radix v4
+func ErrorCode(err error) string {
+	if !isReplyErr(err) {
+		return ""
+	}
+	return retryableredis.ErrorCode(replyError{err: err})
+}
+
+func isReplyErr(err error) bool {
+	return errors.As(err, new(resp3.SimpleError)) || errors.As(err, new(resp3.BlobError))
+}
+
+// replyError wraps an error reply of radix v4, errors.As matches it as a
+// resp2.Error so retryableredis.ErrorCode and the classification work
+type replyError struct {
+	err error
+}
+
+func (e replyError) Error() string {
+	return e.err.Error()
+}
+
+func (e replyError) Unwrap() error {
+	return e.err
+}
+
+func (e replyError) As(target interface{}) bool {
+	respErr, ok := target.(*resp2.Error)
+	if !ok {
+		return false
+	}
+
+	// the code is taken from the start of the message, which the wrapping
+	// done by radix would hide
+	var simpleErr resp3.SimpleError
+	var blobErr resp3.BlobError
+	switch {
+	case errors.As(e.err, &simpleErr):
+		respErr.E = simpleErr
+	case errors.As(e.err, &blobErr):
+		respErr.E = blobErr
+	default:
+		return false
+	}
+	return true
+}
+
+// cmdNames returns the names of the commands a performs, or nil if they can't
+// be determined
+func cmdNames(a radix.Action) []string {
+	m, ok := a.(resp.Marshaler)
+	if !ok {
+		return nil
+	}
+
+	opts := resp.NewOpts()
+	buf := new(bytes.Buffer)
+	if err := m.MarshalRESP(buf, opts); err != nil {
+		return nil
+	}
+
+	var names []string
+	br := bufio.NewReader(buf)
+	for buf.Len() > 0 || br.Buffered() > 0 {
+		var args []string
+		if err := resp3.Unmarshal(br, &args, opts); err != nil || len(args) < 1 {
+			return nil
+		}
+		names = append(names, args[0])
+	}
+	return names
+}
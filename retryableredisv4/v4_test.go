@@ -0,0 +1,57 @@
+package retryableredisv4_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+	"github.com/jonas747/retryableredis/retryableredisv4"
+	"github.com/mediocregopher/radix/v4"
+)
+
+func TestHelloAfterReconnect(t *testing.T) {
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		if args[0] == "GET" {
+			return retryableredistest.Value("v")
+		}
+		return retryableredistest.OK()
+	})
+	defer srv.Close()
+
+	ctx := context.Background()
+	conn, err := retryableredisv4.Dial(ctx, &retryableredisv4.DialConfig{
+		Network: "tcp",
+		Addr:    srv.Addr(),
+		Dialer:  radix.Dialer{Protocol: "3"},
+		RetryConfig: retryableredis.RetryConfig{
+			RetryBackoff: retryableredis.Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// the new connection negotiates RESP3 again before GET is retried on it
+	srv.DropConns()
+	var v string
+	if err := conn.Do(ctx, radix.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+	if v != "v" {
+		t.Fatalf("GET returned %q, want %q", v, "v")
+	}
+
+	var hellos [][]string
+	for _, cmd := range srv.Commands() {
+		if cmd[0] == "HELLO" {
+			hellos = append(hellos, cmd)
+		}
+	}
+	if want := [][]string{{"HELLO", "3"}, {"HELLO", "3"}}; !reflect.DeepEqual(hellos, want) {
+		t.Fatalf("sent %q, want %q", hellos, want)
+	}
+}
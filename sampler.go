@@ -0,0 +1,77 @@
+package retryableredis
+
+import (
+	"sync"
+	"time"
+)
+
+// RetrySampler limits how many retries per second are reported through
+// OnRetry and the Logger, so thousands of commands retrying at once during an
+// outage don't flood them. The retries that weren't reported are summed up
+// once per second in a single "retries suppressed" event instead, see
+// DialConfig.OnRetriesSuppressed.
+//
+// A single RetrySampler can be shared between multiple conns, the summary is
+// then reported through the conn that suppressed the first retry of the
+// second. OnRetryInfo isn't sampled, so metrics built on it stay accurate.
+type RetrySampler struct {
+	perSecond int
+
+	mu          sync.Mutex
+	windowStart time.Time
+	reported    int
+	suppressed  int
+}
+
+// NewRetrySampler returns a RetrySampler reporting up to perSecond retries
+// every second
+func NewRetrySampler(perSecond int) *RetrySampler {
+	return &RetrySampler{perSecond: perSecond}
+}
+
+// allow reports whether a retry should be reported, if it isn't summary is
+// called with the number of suppressed retries once the second is over. A nil
+// sampler allows every retry.
+func (s *RetrySampler) allow(summary func(n int)) bool {
+	if s == nil {
+		return true
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.windowStart) >= time.Second {
+		s.windowStart = now
+		s.reported = 0
+	}
+	if s.reported < s.perSecond {
+		s.reported++
+		return true
+	}
+
+	s.suppressed++
+	if s.suppressed == 1 {
+		time.AfterFunc(time.Second-now.Sub(s.windowStart), func() {
+			s.mu.Lock()
+			n := s.suppressed
+			s.suppressed = 0
+			s.mu.Unlock()
+
+			summary(n)
+		})
+	}
+	return false
+}
+
+func (conf *DialConfig) onRetriesSuppressed(n int) {
+	if conf.Logger != nil {
+		conf.Logger.Warn("redis retries suppressed",
+			"addr", conf.Addr,
+			"count", n,
+			"interval", time.Second)
+	}
+	if conf.OnRetriesSuppressed != nil {
+		conf.OnRetriesSuppressed(n)
+	}
+}
@@ -0,0 +1,138 @@
+package retryableredis
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ScanOpts are the options for NewScanner
+type ScanOpts struct {
+	radix.ScanOpts
+
+	// RestartOnReconnect makes the scan start over from the beginning when
+	// the conn reconnected during it, in case it reconnected to a different
+	// server (e.g after a failover) on which the cursor means nothing. Keys
+	// may then be returned more than once. It's only supported when scanning
+	// a Conn.
+	RestartOnReconnect bool
+}
+
+// NewScanner is like radix.NewScanner, but each page is fetched using Do on c so
+// it's retried, continuing from the same cursor
+func NewScanner(c radix.Client, o ScanOpts) radix.Scanner {
+	s := &scanner{
+		client: c,
+		opts:   o,
+		cursor: "0",
+	}
+	if conn, ok := c.(Conn); ok && o.RestartOnReconnect {
+		s.conn = conn
+		s.reconnects = conn.Stats().Reconnects
+	}
+	return s
+}
+
+type scanner struct {
+	client radix.Client
+	opts   ScanOpts
+
+	// conn is set if the scan restarts on reconnects, reconnects is the
+	// number of reconnects conn made before the last page
+	conn       Conn
+	reconnects uint64
+
+	cursor string
+	keys   []string
+	done   bool
+	err    error
+}
+
+func (s *scanner) Next(res *string) bool {
+	for {
+		if s.err != nil {
+			return false
+		}
+
+		for len(s.keys) > 0 {
+			*res = s.keys[0]
+			s.keys = s.keys[1:]
+			if *res != "" {
+				return true
+			}
+		}
+
+		if s.done {
+			return false
+		}
+
+		var page scanPage
+		if s.err = s.client.Do(s.cmd(&page)); s.err != nil {
+			return false
+		}
+
+		if s.conn != nil {
+			if reconnects := s.conn.Stats().Reconnects; reconnects != s.reconnects {
+				s.reconnects = reconnects
+				s.cursor = "0"
+				s.keys = nil
+				continue
+			}
+		}
+
+		s.cursor = page.cursor
+		s.keys = page.keys
+		s.done = page.cursor == "0"
+	}
+}
+
+func (s *scanner) Close() error {
+	return s.err
+}
+
+// cmd returns the command fetching the page at the current cursor
+func (s *scanner) cmd(rcv *scanPage) radix.CmdAction {
+	cmd := strings.ToUpper(s.opts.Command)
+	args := make([]string, 0, 6)
+	if cmd != "SCAN" {
+		args = append(args, s.opts.Key)
+	}
+
+	args = append(args, s.cursor)
+	if s.opts.Pattern != "" {
+		args = append(args, "MATCH", s.opts.Pattern)
+	}
+	if s.opts.Count > 0 {
+		args = append(args, "COUNT", strconv.Itoa(s.opts.Count))
+	}
+
+	return Cmd(rcv, cmd, args...)
+}
+
+// scanPage is the reply to a SCAN command
+type scanPage struct {
+	cursor string
+	keys   []string
+}
+
+func (p *scanPage) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != 2 {
+		return errors.New("retryableredis: invalid scan reply")
+	}
+
+	var cursor resp2.BulkString
+	if err := cursor.UnmarshalRESP(br); err != nil {
+		return err
+	}
+
+	p.cursor = cursor.S
+	p.keys = p.keys[:0]
+	return (resp2.Any{I: &p.keys}).UnmarshalRESP(br)
+}
@@ -0,0 +1,46 @@
+package retryableredis
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// DefaultDialInterval is the interval between reconnect dials of the conns in
+// a Pool
+const DefaultDialInterval = 10 * time.Millisecond
+
+// DialScheduler staggers the reconnect dials of the conns sharing it, so that
+// when they all lose the server at once they don't all dial in lockstep.
+//
+// Dials are spaced at least Interval apart, randomized by up to half of it.
+type DialScheduler struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+// NewDialScheduler returns a DialScheduler spacing dials interval apart
+func NewDialScheduler(interval time.Duration) *DialScheduler {
+	return &DialScheduler{interval: interval}
+}
+
+// reserve reserves the next free dial slot, returning how long to wait for
+// it. A nil scheduler doesn't wait.
+func (s *DialScheduler) reserve() time.Duration {
+	if s == nil {
+		return 0
+	}
+
+	s.mu.Lock()
+	now := time.Now()
+	at := s.next
+	if at.Before(now) {
+		at = now
+	}
+	s.next = at.Add(s.interval + time.Duration(float64(s.interval)*(rand.Float64()-0.5)))
+	s.mu.Unlock()
+
+	return at.Sub(now)
+}
@@ -0,0 +1,58 @@
+package retryableredis
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"sync"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// ScriptRegistry holds lua scripts that are loaded with SCRIPT LOAD on every
+// new connection, before it's used. This makes EVALSHA work after failing over
+// to a server that never saw the scripts.
+//
+// A single ScriptRegistry can be shared between multiple conns.
+type ScriptRegistry struct {
+	mu      sync.RWMutex
+	scripts map[string]string
+}
+
+// NewScriptRegistry returns an empty ScriptRegistry
+func NewScriptRegistry() *ScriptRegistry {
+	return &ScriptRegistry{scripts: make(map[string]string)}
+}
+
+// Register adds a script, returning the sha1 to run it with using EVALSHA.
+// Conns that are already connected only load it once they reconnect.
+func (r *ScriptRegistry) Register(script string) string {
+	sum := sha1.Sum([]byte(script))
+	sha := hex.EncodeToString(sum[:])
+
+	r.mu.Lock()
+	r.scripts[sha] = script
+	r.mu.Unlock()
+
+	return sha
+}
+
+// load loads all the scripts on conn, a nil registry loads nothing
+func (r *ScriptRegistry) load(conn radix.Conn) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.RLock()
+	scripts := make([]string, 0, len(r.scripts))
+	for _, script := range r.scripts {
+		scripts = append(scripts, script)
+	}
+	r.mu.RUnlock()
+
+	for _, script := range scripts {
+		if err := conn.Do(radix.Cmd(nil, "SCRIPT", "LOAD", script)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package retryableredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// ErrNoSentinel is returned when none of the sentinels could be queried
+var ErrNoSentinel = errors.New("retryableredis: no sentinel reachable")
+
+// ErrMasterSwitched is the cause of the reconnects done when the sentinels
+// announce a new master, see DialConfig.SentinelWatch
+var ErrMasterSwitched = errors.New("retryableredis: sentinel switched master")
+
+// masterAddr returns the address of the current master according to the
+// first sentinel that responds
+func (conf *DialConfig) masterAddr() (string, error) {
+	err := ErrNoSentinel
+	for _, sentinelAddr := range conf.SentinelAddrs {
+		var addr string
+		addr, err = queryMasterAddr(sentinelAddr, conf.SentinelMaster, conf.SentinelDialOpts)
+		if err == nil {
+			return addr, nil
+		}
+	}
+
+	return "", err
+}
+
+func queryMasterAddr(sentinelAddr, master string, opts []radix.DialOpt) (string, error) {
+	conn, err := radix.Dial("tcp", sentinelAddr, opts...)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	var res []string
+	err = conn.Do(radix.Cmd(&res, "SENTINEL", "get-master-addr-by-name", master))
+	if err != nil {
+		return "", err
+	}
+
+	if len(res) != 2 {
+		return "", fmt.Errorf("retryableredis: sentinel %s doesn't know master %q", sentinelAddr, master)
+	}
+
+	return net.JoinHostPort(res[0], res[1]), nil
+}
+
+// watchSentinel subscribes to the +switch-master announcements of the
+// sentinels, reconnecting to the new master as soon as SentinelMaster fails
+// over. It runs until the conn is closed.
+func (rc *retryableRedisConn) watchSentinel() {
+	backoff := rc.config().ReconnectBackoff.orDefault(DefaultReconnectBackoff)
+
+	msgCh := make(chan radix.PubSubMessage, 8)
+	var ps *PubSub
+	for attempt := 0; ; attempt++ {
+		var err error
+		if ps, err = rc.config().sentinelPubSub(); err == nil {
+			// it only fails once the PubSub gave up reconnecting, so it's
+			// started over with a new one
+			if err = ps.Subscribe(msgCh, "+switch-master"); err == nil {
+				break
+			}
+			ps.Close()
+		}
+
+		rc.config().logSentinelWatch(err)
+		if rc.sleep(context.Background(), backoff.Delay(attempt)) != nil {
+			return
+		}
+	}
+	defer ps.Close()
+
+	for {
+		select {
+		case <-rc.closeCh:
+			return
+		case msg := <-msgCh:
+			// <master name> <old ip> <old port> <new ip> <new port>
+			fields := strings.Fields(string(msg.Message))
+			if len(fields) == 5 && fields[0] == rc.config().SentinelMaster {
+				rc.switchMaster(net.JoinHostPort(fields[3], fields[4]))
+			}
+		}
+	}
+}
+
+// sentinelPubSub returns a PubSub connected to the first reachable sentinel,
+// which moves on to the others when reconnecting
+func (conf *DialConfig) sentinelPubSub() (*PubSub, error) {
+	return NewPubSub(&DialConfig{
+		Network:          "tcp",
+		Addrs:            conf.SentinelAddrs,
+		DialOpts:         conf.SentinelDialOpts,
+		ReconnectBackoff: conf.ReconnectBackoff,
+	})
+}
+
+// switchMaster reconnects to the new master at addr, unless already connected
+// to it
+func (rc *retryableRedisConn) switchMaster(addr string) {
+	if err := rc.lockCtx(context.Background()); err != nil {
+		return
+	}
+	defer rc.unlock()
+
+	if rc.inner != nil {
+		if netConn := rc.inner.NetConn(); netConn != nil && netConn.RemoteAddr().String() == addr {
+			return
+		}
+	}
+
+	info := rc.config().newRetryInfo(context.Background(), nil, ErrMasterSwitched)
+	info.Attempt = 1
+	if err := rc.reconnect(info); err != nil {
+		rc.setState(StateDegraded, err)
+	}
+}
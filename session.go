@@ -0,0 +1,325 @@
+package retryableredis
+
+import (
+	"bufio"
+	"bytes"
+	"reflect"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// sessionState is the connection specific state set by commands run through
+// Do, which is lost when reconnecting and has to be set up again
+type sessionState struct {
+	db          string
+	clientName  string
+	readOnly    bool
+	clientReply string
+	// replySkipped is set after CLIENT REPLY SKIP, until the next command
+	replySkipped bool
+
+	// multi is set while a MULTI transaction is open, queued holds the
+	// commands queued in it so far. queueFailed is set if queueing a command
+	// failed, which makes the server fail EXEC.
+	multi       bool
+	queued      [][]string
+	queueFailed bool
+
+	// txnAborted is set when the connection broke while a transaction was
+	// open, until EXEC or DISCARD is sent
+	txnAborted bool
+}
+
+// statefulCommands are the commands tracked by sessionState
+var statefulCommands = map[string]bool{
+	"SELECT":    true,
+	"CLIENT":    true,
+	"READONLY":  true,
+	"READWRITE": true,
+	"MULTI":     true,
+	"EXEC":      true,
+	"DISCARD":   true,
+}
+
+// statefulCmds returns the commands in a that change the session state, which
+// is every command while a transaction is open. It has to be called before
+// performing a, as radix's own actions can't be inspected anymore after that.
+func (s *sessionState) statefulCmds(a radix.Action) [][]string {
+	if s.multi {
+		return actionArgs(a)
+	}
+
+	stateful := false
+	for _, name := range actionCmdNames(a) {
+		if statefulCommands[strings.ToUpper(name)] {
+			stateful = true
+			break
+		}
+	}
+	if !stateful {
+		return nil
+	}
+
+	return actionArgs(a)
+}
+
+// track updates the state with cmds returned by statefulCmds, once they
+// succeeded
+func (s *sessionState) track(cmds [][]string) {
+	s.replySkipped = false
+	for _, args := range cmds {
+		s.trackCmd(args)
+	}
+}
+
+func (s *sessionState) trackCmd(args []string) {
+	name := strings.ToUpper(args[0])
+	if s.multi && name != "EXEC" && name != "DISCARD" {
+		s.queued = append(s.queued, args)
+		return
+	}
+
+	switch name {
+	case "MULTI":
+		s.multi = true
+		s.queued = nil
+		s.queueFailed = false
+	case "EXEC", "DISCARD":
+		s.multi = false
+		s.queued = nil
+	case "SELECT":
+		if len(args) > 1 {
+			s.db = args[1]
+		}
+	case "READONLY":
+		s.readOnly = true
+	case "READWRITE":
+		s.readOnly = false
+	case "CLIENT":
+		if len(args) < 3 {
+			return
+		}
+
+		switch strings.ToUpper(args[1]) {
+		case "SETNAME":
+			s.clientName = args[2]
+		case "REPLY":
+			// SKIP only applies to the next command
+			if mode := strings.ToUpper(args[2]); mode != "SKIP" {
+				s.clientReply = mode
+			} else {
+				s.replySkipped = true
+			}
+		}
+	}
+}
+
+// repliesOff reports whether the server won't reply to the next command
+func (s *sessionState) repliesOff() bool {
+	return s.clientReply == "OFF" || s.replySkipped
+}
+
+// checkTxn returns ErrTxnAborted for the commands in a transaction that was
+// aborted because the connection broke. It has to be called before performing
+// names, the names of the commands in an action.
+func (s *sessionState) checkTxn(names []string) error {
+	if !s.txnAborted {
+		return nil
+	}
+
+	for _, name := range names {
+		switch strings.ToUpper(name) {
+		case "EXEC":
+			s.txnAborted = false
+			return ErrTxnAborted
+		case "DISCARD":
+			// nothing to discard, but the DISCARD itself fails harmlessly
+			s.txnAborted = false
+			return nil
+		}
+	}
+	return ErrTxnAborted
+}
+
+// replay sets up the state on a new connection. An open transaction is opened
+// again with the commands queued so far if replayTxn is set and it can be,
+// otherwise it's aborted.
+func (s *sessionState) replay(conn radix.Conn, replayTxn bool) error {
+	if s.db != "" && s.db != "0" {
+		if err := conn.Do(radix.Cmd(nil, "SELECT", s.db)); err != nil {
+			return err
+		}
+	}
+
+	if s.clientName != "" {
+		if err := conn.Do(radix.Cmd(nil, "CLIENT", "SETNAME", s.clientName)); err != nil {
+			return err
+		}
+	}
+
+	if s.readOnly {
+		if err := conn.Do(radix.Cmd(nil, "READONLY")); err != nil {
+			return err
+		}
+	}
+
+	if s.multi {
+		if !replayTxn || s.queueFailed {
+			s.multi = false
+			s.queued = nil
+			s.txnAborted = true
+		} else if err := s.replayTxn(conn); err != nil {
+			return err
+		}
+	}
+
+	// this has to go last as no reply is sent for it
+	if s.clientReply == "OFF" {
+		if err := conn.Encode(radix.Cmd(nil, "CLIENT", "REPLY", "OFF")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// hasCmd reports whether names contains the command name
+func hasCmd(names []string, name string) bool {
+	for _, n := range names {
+		if strings.EqualFold(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// replayTxn opens the transaction again and queues the commands in it
+func (s *sessionState) replayTxn(conn radix.Conn) error {
+	if err := conn.Do(radix.Cmd(nil, "MULTI")); err != nil {
+		return err
+	}
+
+	for _, args := range s.queued {
+		if err := conn.Do(radix.Cmd(nil, args[0], args[1:]...)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// actionArgs returns the commands performed by a including their arguments,
+// or nil if they can't be determined
+func actionArgs(a radix.Action) [][]string {
+	if p, ok := a.(*pipeline); ok {
+		var res [][]string
+		for _, cmd := range p.cmds {
+			res = append(res, actionArgs(cmd)...)
+		}
+		return res
+	}
+	if r, ok := a.(*retryableAction); ok {
+		return actionArgs(r.newAction())
+	}
+	if r, ok := a.(*retryAction); ok {
+		return actionArgs(r.Action)
+	}
+	if w, ok := a.(wrappedCmd); ok {
+		return actionArgs(w.unwrapCmd())
+	}
+	if c, ok := a.(*capturedCmd); ok {
+		if c.args == nil {
+			return nil
+		}
+		return [][]string{c.args}
+	}
+	switch c := a.(type) {
+	case *RetryableCmd:
+		return [][]string{append([]string{c.cmd}, c.args...)}
+	case *RetryableFlatCmd:
+		if readsArgs(c.args) {
+			// marshaling would read them, they're only known once written
+			if c.wire == nil {
+				return nil
+			}
+			return parseArgs(c.wire.Bytes())
+		}
+
+		buf := new(bytes.Buffer)
+		if err := c.MarshalRESP(buf); err != nil {
+			return nil
+		}
+		return parseArgs(buf.Bytes())
+	}
+
+	// other actions aren't marshaled, see actionCmdNames
+	return nil
+}
+
+// parseArgs returns the commands with their arguments in b
+func parseArgs(b []byte) [][]string {
+	buf := bytes.NewBuffer(b)
+	var res [][]string
+	br := bufio.NewReader(buf)
+	for buf.Len() > 0 || br.Buffered() > 0 {
+		var args []string
+		if err := (resp2.Any{I: &args}).UnmarshalRESP(br); err != nil || len(args) < 1 {
+			return res
+		}
+		res = append(res, args)
+	}
+
+	return res
+}
+
+var lenReaderType = reflect.TypeOf((*resp.LenReader)(nil)).Elem()
+
+// readsArgs reports whether radix reads from args when marshaling them, which
+// it does for a resp.LenReader, so they can only be marshaled once
+func readsArgs(args []interface{}) bool {
+	for _, arg := range args {
+		if readsArg(reflect.ValueOf(arg)) {
+			return true
+		}
+	}
+	return false
+}
+
+func readsArg(v reflect.Value) bool {
+	if !v.IsValid() {
+		return false
+	}
+	if v.Type().Implements(lenReaderType) {
+		return true
+	}
+
+	switch v.Kind() {
+	case reflect.Interface, reflect.Ptr:
+		return !v.IsNil() && readsArg(v.Elem())
+	case reflect.Slice, reflect.Array:
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			return false
+		}
+		for i := 0; i < v.Len(); i++ {
+			if readsArg(v.Index(i)) {
+				return true
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			if readsArg(iter.Key()) || readsArg(iter.Value()) {
+				return true
+			}
+		}
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if v.Type().Field(i).IsExported() && readsArg(v.Field(i)) {
+				return true
+			}
+		}
+	}
+	return false
+}
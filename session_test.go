@@ -0,0 +1,78 @@
+package retryableredis_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestTxnReplayed(t *testing.T) {
+	s := newFlakyServer(t)
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{ReplayTransactions: true})
+
+	for _, args := range [][]string{{"MULTI"}, {"SET", "a", "1"}} {
+		if err := conn.Do(retryableredis.Cmd(nil, args[0], args[1:]...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the transaction is opened again with SET a queued before SET b
+	s.Proxy.Break(retryableredistest.FaultDrop)
+	if err := conn.Do(retryableredis.Cmd(nil, "SET", "b", "2")); err != nil {
+		t.Fatal(err)
+	}
+	var replies []string
+	if err := conn.Do(retryableredis.Cmd(&replies, "EXEC")); err != nil {
+		t.Fatal(err)
+	}
+	cb.check(t)
+
+	if want := []string{"OK", "OK"}; !reflect.DeepEqual(replies, want) {
+		t.Fatalf("EXEC replied %q, want %q", replies, want)
+	}
+	for k, want := range map[string]string{"a": "1", "b": "2"} {
+		if v, _ := s.Redis.Get(k); v != want {
+			t.Fatalf("%s is %q, want %q", k, v, want)
+		}
+	}
+}
+
+func TestTxnAborted(t *testing.T) {
+	s := newFlakyServer(t)
+	var cb callbacks
+	conn := dialProxy(t, s.Addr(), &cb, retryableredis.DialConfig{})
+
+	for _, args := range [][]string{{"MULTI"}, {"SET", "a", "1"}} {
+		if err := conn.Do(retryableredis.Cmd(nil, args[0], args[1:]...)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the rest of the transaction fails instead of running outside of it
+	s.Proxy.Break(retryableredistest.FaultDrop)
+	for _, args := range [][]string{{"SET", "b", "2"}, {"EXEC"}} {
+		err := conn.Do(retryableredis.Cmd(nil, args[0], args[1:]...))
+		if !errors.Is(err, retryableredis.ErrTxnAborted) {
+			t.Fatalf("%s returned %v, want ErrTxnAborted", args[0], err)
+		}
+	}
+	cb.check(t)
+
+	for _, k := range []string{"a", "b"} {
+		if s.Redis.Exists(k) {
+			t.Fatalf("%s was set", k)
+		}
+	}
+
+	// the conn is usable again after EXEC
+	if err := conn.Do(retryableredis.Cmd(nil, "SET", "c", "3")); err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := s.Redis.Get("c"); v != "3" {
+		t.Fatalf("c is %q, want %q", v, "3")
+	}
+}
@@ -0,0 +1,82 @@
+package retryableredis
+
+import (
+	"context"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// beginCall counts a Do call as in progress, reporting false if Shutdown was
+// called already
+func (rc *retryableRedisConn) beginCall() bool {
+	rc.callsMu.Lock()
+	defer rc.callsMu.Unlock()
+
+	if rc.draining {
+		return false
+	}
+	rc.calls++
+	return true
+}
+
+// endCall is called once a call counted by beginCall is done
+func (rc *retryableRedisConn) endCall() {
+	rc.callsMu.Lock()
+	defer rc.callsMu.Unlock()
+
+	rc.calls--
+	if rc.draining && rc.calls == 0 {
+		close(rc.drained)
+	}
+}
+
+// Shutdown implements Conn
+func (rc *retryableRedisConn) Shutdown(ctx context.Context) (int, error) {
+	rc.callsMu.Lock()
+	if rc.draining {
+		rc.callsMu.Unlock()
+		return 0, ErrClosed
+	}
+	rc.draining = true
+	rc.drained = make(chan struct{})
+	if rc.calls == 0 {
+		close(rc.drained)
+	}
+	rc.callsMu.Unlock()
+
+	select {
+	case <-rc.drained:
+		return 0, rc.Close()
+	case <-ctx.Done():
+	}
+
+	rc.callsMu.Lock()
+	abandoned := rc.calls
+	rc.callsMu.Unlock()
+	if !rc.markClosed() {
+		return abandoned, ErrClosed
+	}
+
+	// the lock is held by the attempt in progress, which fails once its
+	// connection is closed and releases it
+	rc.setState(StateClosed, nil)
+	rc.abortInFlight()
+	go rc.closeInner()
+	return abandoned, nil
+}
+
+func (rc *retryableRedisConn) setInFlight(conn radix.Conn) {
+	rc.inFlightMu.Lock()
+	rc.inFlight = conn
+	rc.inFlightMu.Unlock()
+}
+
+// abortInFlight closes the connection of the attempt in progress, if any
+func (rc *retryableRedisConn) abortInFlight() {
+	rc.inFlightMu.Lock()
+	defer rc.inFlightMu.Unlock()
+
+	if rc.inFlight != nil {
+		rc.inFlight.Close()
+	}
+}
@@ -0,0 +1,58 @@
+package retryableredis_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestShutdownDeadline(t *testing.T) {
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		if args[0] == "GET" {
+			return retryableredistest.OK().After(time.Second)
+		}
+		return retryableredistest.OK()
+	})
+	defer srv.Close()
+
+	conn, err := retryableredis.Dial(&retryableredis.DialConfig{
+		Network: "tcp",
+		Addr:    srv.Addr(),
+		Dialer:  srv.Dial,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() { done <- conn.Do(retryableredis.Cmd(nil, "GET", "k")) }()
+	for len(srv.Commands()) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	started := time.Now()
+	abandoned, err := conn.Shutdown(ctx)
+	if took := time.Since(started); took > 200*time.Millisecond {
+		t.Fatalf("Shutdown took %s past its deadline", took)
+	}
+	if err != nil || abandoned != 1 {
+		t.Fatalf("got %d, %v, want 1 abandoned call", abandoned, err)
+	}
+
+	select {
+	case err := <-done:
+		if err == nil {
+			t.Fatal("the abandoned call succeeded")
+		}
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the abandoned call is still in progress")
+	}
+	if state := conn.State(); state != retryableredis.StateClosed {
+		t.Fatalf("got state %s, want closed", state)
+	}
+}
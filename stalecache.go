@@ -0,0 +1,183 @@
+package retryableredis
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ErrStaleResult is matched by StaleResultError, check for it using
+// errors.Is(err, ErrStaleResult)
+var ErrStaleResult = errors.New("retryableredis: stale result")
+
+// StaleResultError is returned by a read that failed but was served from the
+// StaleCache instead, the receiver of the cmd then holds the cached reply
+type StaleResultError struct {
+	// Age is how long ago the cached reply was received
+	Age time.Duration
+
+	// Err is the error the read failed with
+	Err error
+}
+
+func (e *StaleResultError) Error() string {
+	return fmt.Sprintf("retryableredis: serving reply from %s ago: %v", e.Age.Round(time.Millisecond), e.Err)
+}
+
+func (e *StaleResultError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrStaleResult) match
+func (e *StaleResultError) Is(target error) bool {
+	return target == ErrStaleResult
+}
+
+// StaleCache is an LRU of the replies to recent reads, which are used when
+// reading again fails during an outage, see DialConfig.StaleCache. Only the
+// reads CoalesceReads applies to are cached.
+//
+// A single StaleCache can be shared between multiple conns, replies are cached
+// per server and DB.
+type StaleCache struct {
+	size   int
+	maxAge time.Duration
+
+	mu      sync.Mutex
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type staleEntry struct {
+	key   string
+	reply resp2.RawMessage
+	at    time.Time
+}
+
+// NewStaleCache returns a StaleCache holding the replies to up to size reads,
+// which are used for up to maxAge after being received. 0 means any age.
+func NewStaleCache(size int, maxAge time.Duration) *StaleCache {
+	if size < 1 {
+		size = 1
+	}
+
+	return &StaleCache{
+		size:    size,
+		maxAge:  maxAge,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *StaleCache) put(key string, reply resp2.RawMessage) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*staleEntry)
+		e.reply, e.at = reply, time.Now()
+		c.lru.MoveToFront(el)
+		return
+	}
+
+	c.entries[key] = c.lru.PushFront(&staleEntry{key: key, reply: reply, at: time.Now()})
+	if c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*staleEntry).key)
+	}
+}
+
+func (c *StaleCache) get(key string) (resp2.RawMessage, time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, 0, false
+	}
+
+	e := el.Value.(*staleEntry)
+	age := time.Since(e.at)
+	if c.maxAge > 0 && age > c.maxAge {
+		c.lru.Remove(el)
+		delete(c.entries, key)
+		return nil, 0, false
+	}
+	return e.reply, age, true
+}
+
+// doRead performs the read cmd args, unmarshaling the reply into rcv. It's
+// coalesced with identical ones if CoalesceReads is set, and the reply cached
+// in the StaleCache if there's one.
+func (rc *retryableRedisConn) doRead(ctx context.Context, key string, args []string, rcv interface{}) (int, error) {
+	cache := rc.config().StaleCache
+	if cache == nil {
+		return rc.coalescer.do(ctx, key, args, rcv, rc.doPipelined)
+	}
+	cacheKey := rc.staleKey(key)
+
+	var attempts int
+	var err error
+	var raw resp2.RawMessage
+	if rc.config().CoalesceReads {
+		attempts, err = rc.coalescer.do(ctx, key, args, &raw, rc.doPipelined)
+	} else {
+		var reply coalescedReply
+		attempts, err = rc.doPipelined(ctx, Cmd(&reply, args[0], args[1:]...))
+		raw = reply.raw
+	}
+
+	if err == nil {
+		cache.put(cacheKey, raw)
+	} else {
+		// an error reply is the server's answer, unless it was given up on
+		if errors.As(err, new(resp2.Error)) && !gaveUp(err) {
+			return attempts, err
+		}
+
+		cached, age, ok := cache.get(cacheKey)
+		if !ok {
+			return attempts, err
+		}
+		raw, err = cached, &StaleResultError{Age: age, Err: err}
+	}
+
+	if rcv != nil {
+		if uerr := raw.UnmarshalInto(resp2.Any{I: rcv}); uerr != nil {
+			return attempts, uerr
+		}
+	}
+	return attempts, err
+}
+
+// staleKey returns the StaleCache key of the read identified by key, which
+// includes the server and DB the conn uses
+func (rc *retryableRedisConn) staleKey(key string) string {
+	conf := rc.config()
+	server := conf.Addr
+	if conf.SentinelMaster != "" {
+		server = "sentinel " + conf.SentinelMaster
+	}
+
+	rc.dbMu.Lock()
+	db := rc.db
+	rc.dbMu.Unlock()
+	if db == "" {
+		db = strconv.Itoa(conf.DB)
+	}
+	return server + " " + db + " " + key
+}
+
+// setDB records the DB selected by the session
+func (rc *retryableRedisConn) setDB(db string) {
+	rc.dbMu.Lock()
+	rc.db = db
+	rc.dbMu.Unlock()
+}
@@ -0,0 +1,34 @@
+package retryableredis_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonas747/retryableredis"
+)
+
+func TestStaleCacheShared(t *testing.T) {
+	cache := retryableredis.NewStaleCache(10, 0)
+	a, b := miniredis.RunT(t), miniredis.RunT(t)
+	a.Set("k", "a")
+	b.Set("k", "b")
+
+	var cb callbacks
+	conf := retryableredis.DialConfig{StaleCache: cache, MaxRetries: 1, MaxReconnectAttempts: 1}
+	connA := dialProxy(t, a.Addr(), &cb, conf)
+	connB := dialProxy(t, b.Addr(), &cb, conf)
+
+	var v string
+	if err := connA.Do(retryableredis.Cmd(&v, "GET", "k")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the reply from the other server isn't served for it
+	b.Close()
+	v = ""
+	err := connB.Do(retryableredis.Cmd(&v, "GET", "k"))
+	if !errors.Is(err, retryableredis.ErrRetriesExhausted) || v != "" {
+		t.Fatalf("got %q, %v, want no reply", v, err)
+	}
+}
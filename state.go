@@ -0,0 +1,65 @@
+package retryableredis
+
+import "fmt"
+
+// State is the state of a conn
+type State int
+
+const (
+	// StateReconnecting means the conn is (re)connecting, this is also the
+	// state before the initial connect finished
+	StateReconnecting State = iota
+
+	// StateConnected means the last attempt on the connection succeeded, or
+	// failed with a non retryable error reply
+	StateConnected
+
+	// StateDegraded means the server is responding with retryable errors
+	// (e.g LOADING), or that reconnecting gave up and will be tried again on
+	// the next Do call
+	StateDegraded
+
+	// StateClosed means Close was called
+	StateClosed
+)
+
+func (s State) String() string {
+	switch s {
+	case StateReconnecting:
+		return "reconnecting"
+	case StateConnected:
+		return "connected"
+	case StateDegraded:
+		return "degraded"
+	case StateClosed:
+		return "closed"
+	}
+
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// State returns the current state of the conn
+func (rc *retryableRedisConn) State() State {
+	rc.stateMu.Lock()
+	defer rc.stateMu.Unlock()
+
+	return rc.state
+}
+
+// setState updates the state, calling OnStateChange if it changed
+func (rc *retryableRedisConn) setState(s State, cause error) {
+	rc.stateMu.Lock()
+	old := rc.state
+	if old == s || old == StateClosed {
+		rc.stateMu.Unlock()
+		return
+	}
+	rc.state = s
+	rc.stateMu.Unlock()
+
+	rc.queue.setState(s)
+
+	if rc.config().OnStateChange != nil {
+		rc.config().OnStateChange(old, s, cause)
+	}
+}
@@ -0,0 +1,176 @@
+package retryableredis
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats holds statistics about a conn, see Conn.Stats
+type Stats struct {
+	// Retries is the total number of times actions were retried
+	Retries uint64
+
+	// Reconnects is the total number of reconnect attempts, not counting the
+	// initial connect
+	Reconnects uint64
+
+	// LastError is the last error returned by an attempt or a dial, and
+	// LastErrorAt when it happened
+	LastError   error
+	LastErrorAt time.Time
+
+	// LastSuccess is when an attempt last succeeded
+	LastSuccess time.Time
+
+	// Uptime is how long the current connection has been up, 0 if there is
+	// none
+	Uptime time.Duration
+
+	// FirstAttemptLatency holds histograms of the latency of the first
+	// attempt of actions, and Latency of whole Do calls including retries,
+	// keyed by command name. Actions with several commands, including the
+	// ones batched by AutoPipelineWindow, are under "PIPELINE".
+	FirstAttemptLatency map[string]LatencyHistogram
+	Latency             map[string]LatencyHistogram
+
+	// BytesRead and BytesWritten are the total traffic on all connections
+	// since the conn was made, CommandsSent and RepliesReceived the number of
+	// commands and replies in it. They don't include the AUTH and SELECT done
+	// when connecting.
+	BytesRead       uint64
+	BytesWritten    uint64
+	CommandsSent    uint64
+	RepliesReceived uint64
+}
+
+type connStats struct {
+	mu          sync.Mutex
+	retries     uint64
+	reconnects  uint64
+	lastErr     error
+	lastErrAt   time.Time
+	lastSuccess time.Time
+	connectedAt time.Time
+
+	firstAttemptLatency map[string]*LatencyHistogram
+	latency             map[string]*LatencyHistogram
+}
+
+func (s *connStats) retried() {
+	s.mu.Lock()
+	s.retries++
+	s.mu.Unlock()
+}
+
+func (s *connStats) dialed(initial bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !initial {
+		s.reconnects++
+	}
+
+	if err != nil {
+		s.lastErr = err
+		s.lastErrAt = time.Now()
+		s.connectedAt = time.Time{}
+	} else {
+		s.connectedAt = time.Now()
+	}
+}
+
+func (s *connStats) attempted(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err != nil {
+		s.lastErr = err
+		s.lastErrAt = time.Now()
+	} else {
+		s.lastSuccess = time.Now()
+	}
+}
+
+// firstAttempt records the latency of the first attempt of an action
+// performing cmds
+func (s *connStats) firstAttempt(cmds []string, d time.Duration) {
+	s.mu.Lock()
+	s.firstAttemptLatency = recordLatency(s.firstAttemptLatency, cmds, d)
+	s.mu.Unlock()
+}
+
+// done records the latency of a Do call for an action performing cmds
+func (s *connStats) done(cmds []string, d time.Duration) {
+	s.mu.Lock()
+	s.latency = recordLatency(s.latency, cmds, d)
+	s.mu.Unlock()
+}
+
+func recordLatency(hists map[string]*LatencyHistogram, cmds []string, d time.Duration) map[string]*LatencyHistogram {
+	key := latencyKey(cmds)
+	h, ok := hists[key]
+	if !ok {
+		if hists == nil {
+			hists = map[string]*LatencyHistogram{}
+		}
+		h = new(LatencyHistogram)
+		hists[key] = h
+	}
+	h.record(d)
+	return hists
+}
+
+func cloneLatencies(hists map[string]*LatencyHistogram) map[string]LatencyHistogram {
+	res := make(map[string]LatencyHistogram, len(hists))
+	for key, h := range hists {
+		res[key] = h.clone()
+	}
+	return res
+}
+
+func (s *connStats) disconnected() {
+	s.mu.Lock()
+	s.connectedAt = time.Time{}
+	s.mu.Unlock()
+}
+
+// Stats returns statistics about the conn
+func (rc *retryableRedisConn) Stats() Stats {
+	rc.stats.mu.Lock()
+	defer rc.stats.mu.Unlock()
+
+	st := Stats{
+		Retries:     rc.stats.retries,
+		Reconnects:  rc.stats.reconnects,
+		LastError:   rc.stats.lastErr,
+		LastErrorAt: rc.stats.lastErrAt,
+		LastSuccess: rc.stats.lastSuccess,
+
+		FirstAttemptLatency: cloneLatencies(rc.stats.firstAttemptLatency),
+		Latency:             cloneLatencies(rc.stats.latency),
+
+		BytesRead:       atomic.LoadUint64(&rc.traffic.bytesRead),
+		BytesWritten:    atomic.LoadUint64(&rc.traffic.bytesWritten),
+		CommandsSent:    atomic.LoadUint64(&rc.traffic.cmdsSent),
+		RepliesReceived: atomic.LoadUint64(&rc.traffic.repliesRecv),
+	}
+	if !rc.stats.connectedAt.IsZero() {
+		st.Uptime = time.Since(rc.stats.connectedAt)
+	}
+
+	return st
+}
+
+// IsConnected reports whether the state of the conn is StateConnected
+func (rc *retryableRedisConn) IsConnected() bool {
+	return rc.State() == StateConnected
+}
+
+// LastError returns the last error returned by an attempt or a dial
+func (rc *retryableRedisConn) LastError() error {
+	rc.stats.mu.Lock()
+	defer rc.stats.mu.Unlock()
+
+	return rc.stats.lastErr
+}
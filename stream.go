@@ -0,0 +1,272 @@
+package retryableredis
+
+import (
+	"bufio"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// StreamConsumerOpts are the options for NewStreamConsumer, see
+// radix.StreamReaderOpts for the embedded ones
+type StreamConsumerOpts struct {
+	radix.StreamReaderOpts
+
+	// OnReconnect is called when the conn reconnected while consuming, which
+	// means entries that were read before may be delivered again. It's only
+	// supported when consuming from a Conn.
+	OnReconnect func()
+}
+
+// StreamConsumer reads from streams like radix.StreamReader, but keeps track of
+// where it is so that reconnecting doesn't skip entries:
+//
+// With XREAD new entries ("$") are read starting at the last entry in the
+// stream at the time of the first read, rather than the last one at the time
+// of each read.
+//
+// With XREADGROUP the entries pending for the consumer (delivered but not
+// acknowledged) are read first, on start and again after every reconnect,
+// since entries delivered on a broken connection may never have been received.
+type StreamConsumer struct {
+	client radix.Client
+	opts   StreamConsumerOpts
+
+	conn       Conn
+	reconnects uint64
+
+	cmd       string
+	fixedArgs []string
+	blockArgs []string
+	streams   []string
+	ids       map[string]string
+	// pending holds the streams whose pending entries are being read
+	pending map[string]bool
+
+	unread []streamReply
+}
+
+// NewStreamConsumer returns a StreamConsumer reading from c, commands are
+// retried if c is a Conn or Pool. Changes to opts after calling it have no
+// effect.
+func NewStreamConsumer(c radix.Client, opts StreamConsumerOpts) *StreamConsumer {
+	sc := &StreamConsumer{
+		client:  c,
+		opts:    opts,
+		ids:     make(map[string]string, len(opts.Streams)),
+		pending: make(map[string]bool),
+	}
+	if conn, ok := c.(Conn); ok {
+		sc.conn = conn
+		sc.reconnects = conn.Stats().Reconnects
+	}
+
+	group := opts.Group != ""
+	if group {
+		sc.cmd = "XREADGROUP"
+		sc.fixedArgs = []string{"GROUP", opts.Group, opts.Consumer}
+	} else {
+		sc.cmd = "XREAD"
+	}
+	if opts.Count > 0 {
+		sc.fixedArgs = append(sc.fixedArgs, "COUNT", strconv.Itoa(opts.Count))
+	}
+	if !opts.NoBlock {
+		block := 5 * time.Second
+		if opts.Block < 0 {
+			block = 0
+		} else if opts.Block > 0 {
+			block = opts.Block
+		}
+		sc.blockArgs = []string{"BLOCK", strconv.Itoa(int(block / time.Millisecond))}
+	}
+	if group && opts.NoAck {
+		sc.fixedArgs = append(sc.fixedArgs, "NOACK")
+	}
+
+	for stream, id := range opts.Streams {
+		sc.streams = append(sc.streams, stream)
+		switch {
+		case id != nil:
+			sc.ids[stream] = id.String()
+		case group:
+			sc.ids[stream] = ">"
+			sc.pending[stream] = !opts.NoAck
+		default:
+			sc.ids[stream] = "$"
+		}
+	}
+	sc.opts.Streams = nil
+
+	return sc
+}
+
+// Next returns new entries from one of the streams, entries is empty if none
+// were read before the block timeout. Unlike radix.StreamReader errors aren't
+// final, Next can be called again to carry on.
+func (sc *StreamConsumer) Next() (stream string, entries []radix.StreamEntry, err error) {
+	for {
+		if len(sc.unread) == 0 {
+			if err := sc.read(); err != nil {
+				return "", nil, err
+			}
+		}
+
+		donePending := false
+		for len(sc.unread) > 0 {
+			reply := sc.unread[0]
+			sc.unread = sc.unread[1:]
+
+			stream = reply.stream
+			if len(reply.entries) == 0 {
+				if sc.pending[stream] {
+					// done with the pending entries, carry on with new ones
+					sc.pending[stream] = false
+					sc.ids[stream] = ">"
+					donePending = true
+				}
+				continue
+			}
+
+			if sc.cmd == "XREAD" || sc.pending[stream] {
+				sc.ids[stream] = reply.entries[len(reply.entries)-1].ID.String()
+			}
+			return stream, reply.entries, nil
+		}
+
+		if !donePending {
+			return "", nil, nil
+		}
+	}
+}
+
+// read reads the next replies into unread
+func (sc *StreamConsumer) read() error {
+	sc.checkReconnect()
+
+	ids := make([]string, 0, len(sc.streams))
+	pending := false
+	for _, stream := range sc.streams {
+		id, err := sc.id(stream)
+		if err != nil {
+			return err
+		}
+		ids = append(ids, id)
+		pending = pending || sc.pending[stream]
+	}
+
+	args := append([]string{}, sc.fixedArgs...)
+	// pending entries are there already or not at all, so there is no point
+	// blocking for them
+	if !pending {
+		args = append(args, sc.blockArgs...)
+	}
+	args = append(args, "STREAMS")
+	args = append(args, sc.streams...)
+	args = append(args, ids...)
+
+	var replies []streamReply
+	err := sc.client.Do(Cmd(&replies, sc.cmd, args...))
+	if err != nil {
+		// entries may have been delivered on the broken connection
+		sc.startPending()
+		return err
+	}
+
+	// replies received on a new connection may be missing entries delivered
+	// on the old one, read them again
+	if sc.checkReconnect() {
+		replies = nil
+	}
+
+	sc.unread = replies
+	return nil
+}
+
+// id returns the ID to read a stream from
+func (sc *StreamConsumer) id(stream string) (string, error) {
+	id := sc.ids[stream]
+	if sc.pending[stream] {
+		if id == ">" {
+			id = "0"
+			sc.ids[stream] = id
+		}
+		return id, nil
+	}
+
+	if id != "$" {
+		return id, nil
+	}
+
+	// pin down the last entry so that reconnecting doesn't skip entries
+	var last []radix.StreamEntry
+	if err := sc.client.Do(Cmd(&last, "XREVRANGE", stream, "+", "-", "COUNT", "1")); err != nil {
+		return "", err
+	}
+
+	id = "0-0"
+	if len(last) > 0 {
+		id = last[0].ID.String()
+	}
+	sc.ids[stream] = id
+	return id, nil
+}
+
+// checkReconnect calls OnReconnect and reads the pending entries again if conn
+// reconnected since the last check, reporting whether it did
+func (sc *StreamConsumer) checkReconnect() bool {
+	if sc.conn == nil {
+		return false
+	}
+
+	reconnects := sc.conn.Stats().Reconnects
+	if reconnects == sc.reconnects {
+		return false
+	}
+	sc.reconnects = reconnects
+
+	sc.startPending()
+	if sc.opts.OnReconnect != nil {
+		sc.opts.OnReconnect()
+	}
+	return true
+}
+
+// startPending makes the next read start with the pending entries
+func (sc *StreamConsumer) startPending() {
+	if sc.cmd != "XREADGROUP" || sc.opts.NoAck {
+		return
+	}
+
+	for _, stream := range sc.streams {
+		if sc.ids[stream] == ">" {
+			sc.pending[stream] = true
+		}
+	}
+}
+
+// streamReply is the reply to XREAD for a single stream
+type streamReply struct {
+	stream  string
+	entries []radix.StreamEntry
+}
+
+func (r *streamReply) UnmarshalRESP(br *bufio.Reader) error {
+	var ah resp2.ArrayHeader
+	if err := ah.UnmarshalRESP(br); err != nil {
+		return err
+	} else if ah.N != 2 {
+		return errors.New("retryableredis: invalid xread reply")
+	}
+
+	var stream resp2.BulkString
+	if err := stream.UnmarshalRESP(br); err != nil {
+		return err
+	}
+	r.stream = stream.S
+
+	return (resp2.Any{I: &r.entries}).UnmarshalRESP(br)
+}
@@ -0,0 +1,56 @@
+package retryableredis
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// usesTLS reports whether any of the TLS fields is set
+func (conf *DialConfig) usesTLS() bool {
+	return conf.TLSConfig != nil || conf.TLSCAFile != "" || conf.TLSCertFile != "" ||
+		conf.TLSServerName != "" || conf.TLSInsecureSkipVerify
+}
+
+// tlsConfig returns the TLS config to dial with, loading the certificate files,
+// or nil if TLS isn't used
+func (conf *DialConfig) tlsConfig() (*tls.Config, error) {
+	if !conf.usesTLS() {
+		return nil, nil
+	}
+
+	tlsConf := &tls.Config{}
+	if conf.TLSConfig != nil {
+		tlsConf = conf.TLSConfig.Clone()
+	}
+
+	if conf.TLSServerName != "" {
+		tlsConf.ServerName = conf.TLSServerName
+	}
+	if conf.TLSInsecureSkipVerify {
+		tlsConf.InsecureSkipVerify = true
+	}
+
+	if conf.TLSCAFile != "" {
+		pem, err := ioutil.ReadFile(conf.TLSCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("retryableredis: reading TLS CA file: %v", err)
+		}
+
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("retryableredis: no certificates in TLS CA file %s", conf.TLSCAFile)
+		}
+	}
+
+	if conf.TLSCertFile != "" || conf.TLSKeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(conf.TLSCertFile, conf.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("retryableredis: loading TLS client certificate: %v", err)
+		}
+		tlsConf.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConf, nil
+}
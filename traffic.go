@@ -0,0 +1,112 @@
+package retryableredis
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"sync/atomic"
+
+	"github.com/mediocregopher/radix/v3"
+	"github.com/mediocregopher/radix/v3/resp"
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// trafficCounters count the traffic on all the connections of a conn, see
+// Stats. It's allocated on its own to keep the counters 64-bit aligned.
+type trafficCounters struct {
+	bytesRead, bytesWritten uint64
+	cmdsSent, repliesRecv   uint64
+}
+
+// countingConn is a radix.Conn whose traffic is counted
+type countingConn struct {
+	radix.Conn
+	traffic *trafficCounters
+
+	// br reads from the bufio.Reader of the wrapped conn, so the bytes
+	// taken from it can be counted
+	br *bufio.Reader
+
+	// written is set once a request was fully written, see
+	// DialConfig.DeliveryMode
+	written bool
+}
+
+// readerGrabber gets at the bufio.Reader Decode is called with
+type readerGrabber struct {
+	br *bufio.Reader
+}
+
+func (g *readerGrabber) UnmarshalRESP(br *bufio.Reader) error {
+	g.br = br
+	return nil
+}
+
+// countTraffic wraps conn so its traffic is counted in traffic
+func countTraffic(conn radix.Conn, traffic *trafficCounters) (radix.Conn, error) {
+	var g readerGrabber
+	if err := conn.Decode(&g); err != nil {
+		return nil, err
+	}
+
+	cc := &countingConn{Conn: conn, traffic: traffic}
+	cc.br = bufio.NewReader(&countingReader{r: g.br, n: &traffic.bytesRead})
+	return cc, nil
+}
+
+func (cc *countingConn) Do(a radix.Action) error {
+	return a.Run(cc)
+}
+
+func (cc *countingConn) Encode(m resp.Marshaler) error {
+	cmds := 1
+	if pc, ok := m.(pipelineCmds); ok {
+		cmds = len(pc)
+	}
+
+	err := cc.Conn.Encode(countingMarshaler{m: m, n: &cc.traffic.bytesWritten})
+	if err == nil {
+		cc.written = true
+		atomic.AddUint64(&cc.traffic.cmdsSent, uint64(cmds))
+	}
+	return err
+}
+
+func (cc *countingConn) Decode(u resp.Unmarshaler) error {
+	err := u.UnmarshalRESP(cc.br)
+	if err == nil || errors.As(err, new(resp2.Error)) {
+		atomic.AddUint64(&cc.traffic.repliesRecv, 1)
+	}
+	return err
+}
+
+type countingMarshaler struct {
+	m resp.Marshaler
+	n *uint64
+}
+
+func (cm countingMarshaler) MarshalRESP(w io.Writer) error {
+	return cm.m.MarshalRESP(&countingWriter{w: w, n: cm.n})
+}
+
+type countingWriter struct {
+	w io.Writer
+	n *uint64
+}
+
+func (cw *countingWriter) Write(b []byte) (int, error) {
+	n, err := cw.w.Write(b)
+	atomic.AddUint64(cw.n, uint64(n))
+	return n, err
+}
+
+type countingReader struct {
+	r io.Reader
+	n *uint64
+}
+
+func (cr *countingReader) Read(b []byte) (int, error) {
+	n, err := cr.r.Read(b)
+	atomic.AddUint64(cr.n, uint64(n))
+	return n, err
+}
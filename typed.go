@@ -0,0 +1,47 @@
+package retryableredis
+
+import (
+	"github.com/mediocregopher/radix/v3"
+)
+
+// TypedCmd is a Cmd whose reply is unmarshaled into a T, made with CmdT
+type TypedCmd[T any] struct {
+	*RetryableCmd
+	val T
+}
+
+// wrappedCmd is implemented by TypedCmd and MaybeNilCmd, whose Cmd or FlatCmd
+// is what's actually performed
+type wrappedCmd interface {
+	unwrapCmd() radix.CmdAction
+}
+
+// CmdT is like Cmd, with the reply unmarshaled into a T which is returned by
+// Val once it's performed. Replies into basic types like string, int and
+// []byte are unmarshaled without reflection.
+func CmdT[T any](cmd string, args ...string) *TypedCmd[T] {
+	c := new(TypedCmd[T])
+	c.RetryableCmd = Cmd(&c.val, cmd, args...).(*RetryableCmd)
+	return c
+}
+
+// Val returns the reply of the cmd
+func (c *TypedCmd[T]) Val() T {
+	return c.val
+}
+
+func (c *TypedCmd[T]) unwrapCmd() radix.CmdAction {
+	return c.RetryableCmd
+}
+
+// DoT performs a CmdT on c and returns its reply, releasing the cmd
+// afterwards:
+//
+//	n, err := retryableredis.DoT[int](conn, "INCR", "counter")
+func DoT[T any](c radix.Client, cmd string, args ...string) (T, error) {
+	tc := CmdT[T](cmd, args...)
+	err := c.Do(tc)
+	val := tc.val
+	tc.Release()
+	return val, err
+}
@@ -0,0 +1,77 @@
+package retryableredis
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// DialURL is like Dial, but connects to the server described by a redis URL:
+//
+//	redis://[[username]:password@]host[:port][/db]
+//	rediss://[[username]:password@]host[:port][/db]
+//	unix://[[username]:password@]/path/to/socket[?db=db]
+//
+// rediss URLs connect using TLS. The db and password can also be given as
+// query parameters. The other fields of conf are used as-is, its Network,
+// Addr, Username, Password and DB are overwritten.
+func DialURL(rawURL string, conf *DialConfig) (Conn, error) {
+	c := DialConfig{}
+	if conf != nil {
+		c = *conf
+	}
+
+	if err := c.parseURL(rawURL); err != nil {
+		return nil, err
+	}
+	return Dial(&c)
+}
+
+// parseURL applies rawURL to conf
+func (conf *DialConfig) parseURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("retryableredis: invalid url: %v", err)
+	}
+
+	q := u.Query()
+	db := q.Get("db")
+	switch u.Scheme {
+	case "redis", "rediss":
+		conf.Network = "tcp"
+		conf.Addr = u.Host
+		if u.Port() == "" {
+			conf.Addr = net.JoinHostPort(u.Hostname(), "6379")
+		}
+		if path := strings.Trim(u.Path, "/"); path != "" {
+			db = path
+		}
+
+		if u.Scheme == "rediss" && !conf.usesTLS() {
+			conf.TLSConfig = &tls.Config{}
+		}
+	case "unix":
+		conf.Network = "unix"
+		conf.Addr = u.Path
+	default:
+		return fmt.Errorf("retryableredis: unsupported url scheme %q", u.Scheme)
+	}
+
+	conf.Username = u.User.Username()
+	conf.Password, _ = u.User.Password()
+	if pass := q.Get("password"); pass != "" {
+		conf.Password = pass
+	}
+
+	conf.DB = 0
+	if db != "" {
+		if conf.DB, err = strconv.Atoi(db); err != nil {
+			return fmt.Errorf("retryableredis: invalid db %q in url", db)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,109 @@
+package retryableredis
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/mediocregopher/radix/v3/resp/resp2"
+)
+
+// ErrNotReplicated is matched by ReplicationError, check for it using
+// errors.Is(err, ErrNotReplicated)
+var ErrNotReplicated = errors.New("retryableredis: write not replicated")
+
+// ReplicationError is returned when the WAIT after a write, see
+// DialConfig.WaitReplicas, timed out before enough replicas acknowledged it.
+// The write itself succeeded and isn't retried.
+type ReplicationError struct {
+	// Acked is the number of replicas that acknowledged the write, and
+	// Wanted the number that was waited for
+	Acked, Wanted int
+}
+
+func (e *ReplicationError) Error() string {
+	return fmt.Sprintf("retryableredis: write acknowledged by %d of %d replicas", e.Acked, e.Wanted)
+}
+
+// Is makes errors.Is(err, ErrNotReplicated) match
+func (e *ReplicationError) Is(target error) bool {
+	return target == ErrNotReplicated
+}
+
+// ErrReplicationUnconfirmed is matched by ReplicationUnconfirmedError, check
+// for it using errors.Is(err, ErrReplicationUnconfirmed)
+var ErrReplicationUnconfirmed = errors.New("retryableredis: replication unconfirmed")
+
+// ReplicationUnconfirmedError is returned when the WAIT after a write failed,
+// e.g because the connection broke. The write itself succeeded and isn't
+// retried, only whether it was replicated is unknown.
+type ReplicationUnconfirmedError struct {
+	// Err is the error the WAIT failed with
+	Err error
+}
+
+func (e *ReplicationUnconfirmedError) Error() string {
+	return fmt.Sprintf("retryableredis: write succeeded, replication unconfirmed: %v", e.Err)
+}
+
+func (e *ReplicationUnconfirmedError) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is(err, ErrReplicationUnconfirmed) match
+func (e *ReplicationUnconfirmedError) Is(target error) bool {
+	return target == ErrReplicationUnconfirmed
+}
+
+// WithActionWait sets the number of replicas to WAIT for after the action and
+// the timeout, overriding WaitReplicas and WaitTimeout. 0 replicas disables
+// the WAIT.
+func WithActionWait(replicas int, timeout time.Duration) RetryOption {
+	return func(o *retryOpts) {
+		o.wait = &waitOpts{replicas: replicas, timeout: timeout}
+	}
+}
+
+type waitOpts struct {
+	replicas int
+	timeout  time.Duration
+}
+
+// actionWait returns the WAIT to perform after a successful attempt of an
+// action with the options o
+func (conf *DialConfig) actionWait(o retryOpts) waitOpts {
+	if o.wait != nil {
+		return *o.wait
+	}
+	return waitOpts{replicas: conf.WaitReplicas, timeout: conf.WaitTimeout}
+}
+
+// wait performs a WAIT on the current connection, returning a
+// ReplicationError if fewer than w.replicas acknowledged the writes before it.
+// If the WAIT fails a ReplicationUnconfirmedError is returned, and the
+// connection is dropped if it broke. rc.lock has to be held.
+func (rc *retryableRedisConn) wait(w waitOpts) error {
+	var acked int
+	cmd := Cmd(&acked, "WAIT", strconv.Itoa(w.replicas), strconv.FormatInt(w.timeout.Milliseconds(), 10))
+	defer Release(cmd)
+
+	if err := rc.doInner(cmd); err != nil {
+		if !errors.As(err, new(resp2.Error)) {
+			rc.inner.Close()
+			rc.inner = nil
+			rc.stats.disconnected()
+		}
+		return &ReplicationUnconfirmedError{Err: err}
+	}
+	if acked < w.replicas {
+		return &ReplicationError{Acked: acked, Wanted: w.replicas}
+	}
+	return nil
+}
+
+// needsWait reports whether a WAIT has to follow an action, which is the case
+// for writes outside of a MULTI
+func (rc *retryableRedisConn) needsWait(write bool, w waitOpts) bool {
+	return w.replicas > 0 && !rc.session.multi && write
+}
@@ -0,0 +1,50 @@
+package retryableredis_test
+
+import (
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/jonas747/retryableredis"
+	"github.com/jonas747/retryableredis/retryableredistest"
+)
+
+func TestWaitFailureNotRetried(t *testing.T) {
+	var mu sync.Mutex
+	var incrs int
+	srv := retryableredistest.NewServer(func(args []string) retryableredistest.Reply {
+		mu.Lock()
+		defer mu.Unlock()
+		switch args[0] {
+		case "INCR":
+			incrs++
+			return retryableredistest.Value(incrs)
+		case "WAIT":
+			return retryableredistest.Drop()
+		}
+		return retryableredistest.OK()
+	})
+	defer srv.Close()
+
+	conn, err := retryableredis.Dial(&retryableredis.DialConfig{
+		Network:          "tcp",
+		Addr:             srv.Addr(),
+		Dialer:           srv.Dial,
+		ReconnectBackoff: testBackoff,
+		WaitReplicas:     1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	err = conn.Do(retryableredis.Cmd(nil, "INCR", "k"))
+	if !errors.Is(err, retryableredis.ErrReplicationUnconfirmed) {
+		t.Fatalf("got %v, want ErrReplicationUnconfirmed", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if incrs != 1 {
+		t.Fatalf("INCR performed %d times, want 1", incrs)
+	}
+}
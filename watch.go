@@ -0,0 +1,86 @@
+package retryableredis
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/mediocregopher/radix/v3"
+)
+
+// ErrWatchConflict is returned by the transaction run by Watch when a watched
+// key was modified before EXEC, it's retried like a retryable error reply
+var ErrWatchConflict = errors.New("retryableredis: watched keys were modified")
+
+// Watch runs an optimistic transaction: it WATCHes keys and calls fn, which
+// reads the keys and then queues the writes after sending MULTI, Watch then
+// sends EXEC and unmarshals its reply, the replies of the queued commands,
+// into rcv. If a watched key was modified in the meantime, or the connection
+// broke, the whole thing including fn is retried.
+//
+// fn must only use the conn it's given. If fn returns an error the
+// transaction is discarded. If fn doesn't send MULTI nothing is executed and
+// rcv is left as is.
+func (rc *retryableRedisConn) Watch(rcv interface{}, keys []string, fn func(conn radix.Conn) error) error {
+	return rc.Do(&watchAction{rcv: rcv, keys: keys, fn: fn})
+}
+
+// watchAction runs a WATCH/MULTI/EXEC transaction
+type watchAction struct {
+	rcv  interface{}
+	keys []string
+	fn   func(conn radix.Conn) error
+}
+
+func (w *watchAction) Keys() []string {
+	return w.keys
+}
+
+func (w *watchAction) Run(conn radix.Conn) error {
+	if err := conn.Do(radix.Cmd(nil, "WATCH", w.keys...)); err != nil {
+		return err
+	}
+
+	txn := &txnConn{Conn: conn}
+	if err := w.fn(txn); err != nil {
+		if txn.multi {
+			conn.Do(radix.Cmd(nil, "DISCARD"))
+		} else {
+			conn.Do(radix.Cmd(nil, "UNWATCH"))
+		}
+		return err
+	}
+
+	if !txn.multi {
+		return conn.Do(radix.Cmd(nil, "UNWATCH"))
+	}
+
+	mn := radix.MaybeNil{Rcv: w.rcv}
+	if err := conn.Do(radix.Cmd(&mn, "EXEC")); err != nil {
+		return err
+	}
+	if mn.Nil {
+		return ErrWatchConflict
+	}
+	return nil
+}
+
+// txnConn tracks whether MULTI was sent on it
+type txnConn struct {
+	radix.Conn
+	multi bool
+}
+
+func (c *txnConn) Do(a radix.Action) error {
+	a = captureCmd(a)
+	names := actionCmdNames(a)
+	err := c.Conn.Do(a)
+	for _, name := range names {
+		switch strings.ToUpper(name) {
+		case "MULTI":
+			c.multi = err == nil
+		case "EXEC", "DISCARD":
+			c.multi = false
+		}
+	}
+	return err
+}
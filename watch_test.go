@@ -0,0 +1,55 @@
+package retryableredis_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jonas747/retryableredis"
+	"github.com/mediocregopher/radix/v3"
+)
+
+func TestWatchConflict(t *testing.T) {
+	m := miniredis.RunT(t)
+	var cb callbacks
+	conn := dialProxy(t, m.Addr(), &cb, retryableredis.DialConfig{})
+	other, err := radix.Dial("tcp", m.Addr())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	calls := 0
+	var replies []string
+	err = conn.Watch(&replies, []string{"k"}, func(conn radix.Conn) error {
+		calls++
+		var v string
+		if err := conn.Do(radix.Cmd(&v, "GET", "k")); err != nil {
+			return err
+		}
+		if calls == 1 {
+			// modifying the watched key makes it run again
+			if err := other.Do(radix.Cmd(nil, "SET", "k", "other")); err != nil {
+				return err
+			}
+		}
+		if err := conn.Do(radix.Cmd(nil, "MULTI")); err != nil {
+			return err
+		}
+		if err := conn.Do(radix.Cmd(nil, "SET", "k", v+"!")); err != nil {
+			return err
+		}
+		return conn.Do(radix.Cmd(nil, "GET", "k"))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if calls != 2 {
+		t.Fatalf("fn was called %d times, want 2", calls)
+	}
+	// EXEC's reply has the replies of the queued commands
+	if want := []string{"OK", "other!"}; !reflect.DeepEqual(replies, want) {
+		t.Fatalf("EXEC replied %q, want %q", replies, want)
+	}
+}